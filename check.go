@@ -0,0 +1,39 @@
+package main
+
+import "errors"
+
+// Severity classifies a Diagnostic. There are only errors until the
+// compiler has something to warn about.
+type Severity byte
+
+const (
+	SeverityError Severity = iota
+)
+
+// Diagnostic is a single problem reported by Check.
+type Diagnostic struct {
+	severity Severity
+	line     int
+	column   int
+	msg      string
+}
+
+// Check scans and compiles source without executing it and reports what
+// it finds. The compiler stops at its first error and has no warnings yet,
+// so at most one diagnostic is returned. opts configure the compiler as
+// for newCompiler, for example to set the tab width used for columns.
+func Check(source string, opts ...compilerOption) []Diagnostic {
+	_, err := newCompiler(opts...).compile(source)
+	if err == nil {
+		return nil
+	}
+
+	d := Diagnostic{severity: SeverityError, msg: err.Error()}
+
+	var cerr *CompileError
+	if errors.As(err, &cerr) {
+		d.line, d.column, d.msg = cerr.line, cerr.column, cerr.msg
+	}
+
+	return []Diagnostic{d}
+}
@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestCheck(t *testing.T) {
+	if diags := Check("1 + 2 *\n  (3 <=> 4)"); diags != nil {
+		t.Errorf("valid program: got %v", diags)
+	}
+
+	tests := []struct {
+		source string
+		want   Diagnostic
+	}{
+		{"1 +\n  )", Diagnostic{SeverityError, 2, 3, "unexpected ')'"}},
+		{"(1 + 2", Diagnostic{SeverityError, 1, 7, "expected ')' but reached end of input"}},
+		{"1 @ 2", Diagnostic{SeverityError, 1, 3, "unexpected character"}},
+		{"1 +\n0b2", Diagnostic{SeverityError, 2, 1, "invalid number literal '0b2'"}},
+	}
+
+	for _, test := range tests {
+		diags := Check(test.source)
+		if len(diags) != 1 || diags[0] != test.want {
+			t.Errorf("%q: got %v, want [%v]", test.source, diags, test.want)
+		}
+	}
+}
+
+func TestCheckDoesNotRun(t *testing.T) {
+	// dividing by nil would be a runtime error, which Check never reaches
+	if diags := Check("1 / nil"); diags != nil {
+		t.Errorf("got %v, want no diagnostics", diags)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ToText renders the chunk as an assembler-like listing, one instruction
+// per line in the form "line:column mnemonic [operand]". Constant operands
+// are written as literal values rather than table indices so the listing
+// can be edited by hand and read back with ParseText. A corrupt constant
+// operand is marked as dumpOp marks it, and will not parse back.
+func (c *Chunk) ToText() string {
+	var b strings.Builder
+
+	for offset := 0; offset < len(c.code); {
+		op := Op(c.code[offset])
+		line, column := c.positionAt(offset)
+		fmt.Fprintf(&b, "%d:%d %v", line, column, op)
+
+		switch op {
+		case OpConstant:
+			switch {
+			case offset+1 >= len(c.code):
+				b.WriteString(" <missing operand>")
+			case int(c.code[offset+1]) >= len(c.vals):
+				fmt.Fprintf(&b, " %d <out of range>", c.code[offset+1])
+			default:
+				fmt.Fprintf(&b, " %s", formatConstant(c.vals[c.code[offset+1]]))
+			}
+			offset += 2
+		default:
+			offset++
+		}
+
+		b.WriteByte('\n')
+	}
+
+	return b.String()
+}
+
+// DumpConstants lists the chunk's constant table, one entry per line with
+// its index, type and value.
+func (c *Chunk) DumpConstants() string {
+	var b strings.Builder
+	for i, val := range c.vals {
+		fmt.Fprintf(&b, "%04d %-6s %s\n", i, valueTypeNames[val.typ], val)
+	}
+	return b.String()
+}
+
+// ParseText reads a listing produced by ToText back into a chunk. Blank
+// lines and lines starting with ';' are ignored.
+func ParseText(s string) (*Chunk, error) {
+	chunk := &Chunk{}
+
+	ops := map[string]Op{}
+	for op := OpConstant; op <= OpReturn; op++ {
+		ops[fmt.Sprint(op)] = op
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(s))
+	for n := 1; scanner.Scan(); n++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, ";") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("%d: expected position and mnemonic", n)
+		}
+
+		var line, column int
+		if _, err := fmt.Sscanf(fields[0], "%d:%d", &line, &column); err != nil {
+			return nil, fmt.Errorf("%d: invalid position '%s'", n, fields[0])
+		}
+
+		op, ok := ops[fields[1]]
+		if !ok {
+			return nil, fmt.Errorf("%d: unknown mnemonic '%s'", n, fields[1])
+		}
+		chunk.addOp(op, line, column)
+
+		switch op {
+		case OpConstant:
+			if len(fields) != 3 {
+				return nil, fmt.Errorf("%d: %s takes one operand", n, fields[1])
+			}
+			val, err := parseConstant(fields[2])
+			if err != nil {
+				return nil, fmt.Errorf("%d: %s", n, err)
+			}
+			index := chunk.addVal(val)
+			if index > 255 {
+				return nil, fmt.Errorf("%d: too many constants", n)
+			}
+			chunk.addByte(byte(index), line, column)
+		default:
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("%d: %s takes no operands", n, fields[1])
+			}
+		}
+	}
+
+	return chunk, scanner.Err()
+}
+
+func formatConstant(v Value) string {
+	if v.typ == ValueNumber {
+		return strconv.FormatFloat(v.asNumber(), 'g', -1, 64)
+	}
+	return v.String()
+}
+
+func parseConstant(s string) (Value, error) {
+	switch s {
+	case "nil":
+		return nilValue(), nil
+	case "true":
+		return boolValue(true), nil
+	case "false":
+		return boolValue(false), nil
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return Value{}, fmt.Errorf("invalid constant '%s'", s)
+	}
+	return numberValue(f), nil
+}
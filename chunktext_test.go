@@ -0,0 +1,115 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+// runOutput runs chunk without tracing and returns what it printed.
+func runOutput(t *testing.T, chunk *Chunk) string {
+	t.Helper()
+	var err error
+	out := capture(t, &os.Stdout, func() {
+		err = (&vm{}).run(chunk)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestTextRoundTrip(t *testing.T) {
+	programs := []string{
+		"1 + 2 * -3",
+		"(0.25 <=> 1e3) == -1",
+		"!nil != true\n  == (1 < 2 ** .5)",
+	}
+
+	for _, source := range programs {
+		chunk, err := newCompiler().compile(source)
+		if err != nil {
+			t.Fatalf("%s: %s", source, err)
+		}
+
+		text := chunk.ToText()
+		parsed, err := ParseText(text)
+		if err != nil {
+			t.Errorf("%s: %s\n%s", source, err, text)
+			continue
+		}
+
+		if !reflect.DeepEqual(parsed.code, chunk.code) ||
+			!reflect.DeepEqual(parsed.vals, chunk.vals) ||
+			!reflect.DeepEqual(parsed.lines, chunk.lines) ||
+			!reflect.DeepEqual(parsed.columns, chunk.columns) {
+			t.Errorf("%s: round trip differs:\n%s\n%s", source, text, parsed.ToText())
+		}
+
+		if got, want := runOutput(t, parsed), runOutput(t, chunk); got != want {
+			t.Errorf("%s: parsed chunk printed %q, want %q", source, got, want)
+		}
+	}
+}
+
+func TestToTextCorruptOperand(t *testing.T) {
+	tests := []struct {
+		code []byte
+		want string
+	}{
+		{[]byte{byte(OpConstant), 9, byte(OpReturn)}, "1:1 OpConstant 9 <out of range>\n1:1 OpReturn\n"},
+		{[]byte{byte(OpConstant)}, "1:1 OpConstant <missing operand>\n"},
+	}
+
+	for _, test := range tests {
+		chunk := &Chunk{}
+		for _, b := range test.code {
+			chunk.addByte(b, 1, 1)
+		}
+
+		text := chunk.ToText()
+		if text != test.want {
+			t.Errorf("%v: got %q, want %q", test.code, text, test.want)
+		}
+		if _, err := ParseText(text); err == nil {
+			t.Errorf("%v: corrupt listing parsed back", test.code)
+		}
+	}
+}
+
+func TestParseTextErrors(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"1:1 OpFrobnicate", "1: unknown mnemonic 'OpFrobnicate'"},
+		{"; comment\n\n1:1 OpConstant", "3: OpConstant takes one operand"},
+		{"1:1 OpAdd 2", "1: OpAdd takes no operands"},
+		{"1:1 OpConstant maybe", "1: invalid constant 'maybe'"},
+		{"one OpReturn", "1: invalid position 'one'"},
+		{"1:1 Op(200)", "1: unknown mnemonic 'Op(200)'"},
+	}
+
+	for _, test := range tests {
+		_, err := ParseText(test.text)
+		if err == nil || err.Error() != test.want {
+			t.Errorf("%q: got error %v, want %s", test.text, err, test.want)
+		}
+	}
+}
+
+func TestDumpConstants(t *testing.T) {
+	chunk, err := newCompiler().compile("1 + 2.5 * 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// constants are not deduplicated yet, so the repeated 1 is listed twice
+	want := "" +
+		"0000 number 1.000000\n" +
+		"0001 number 2.500000\n" +
+		"0002 number 1.000000\n"
+	if got := chunk.DumpConstants(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
@@ -7,6 +7,7 @@ import (
 
 type Compiler interface {
 	compile(source string) (*Chunk, error)
+	compileExpression(source string) (*Chunk, error)
 }
 
 type precedence byte
@@ -21,6 +22,7 @@ const (
 	precTerm                  // + -
 	precFactor                // * /
 	precUnary                 // - !
+	precPower                 // **
 	precCall                  // . ()
 	precPrimary
 )
@@ -33,51 +35,167 @@ type parseRule struct {
 	precedence precedence
 }
 
+// defaultMaxDepth bounds how deeply parse may recurse into nested
+// expressions before compilation is aborted.
+const defaultMaxDepth = 512
+
 type compiler struct {
 	scanner    Scanner
 	parseRules map[TokenType]parseRule
 	current    Token
 	previous   Token
+	depth      int
+	maxDepth   int
+
+	// name identifies the source in error messages, e.g. a file name.
+	name string
+
+	// transform, if set, rewrites each scanned token into zero or more
+	// tokens; pending buffers its output until advance consumes it.
+	transform func(Token) []Token
+	pending   []Token
+
+	// scannerOpts configure the scanner created for each compilation.
+	scannerOpts []scannerOption
+}
+
+type compilerOption func(*compiler)
+
+// withMaxDepth overrides the expression nesting limit.
+func withMaxDepth(n int) compilerOption {
+	return func(c *compiler) {
+		c.maxDepth = n
+	}
+}
+
+// withSourceName sets the name, such as a file name or "<repl>", that
+// prefixes compile and runtime error messages.
+func withSourceName(name string) compilerOption {
+	return func(c *compiler) {
+		c.name = name
+	}
+}
+
+// withTokenTransform installs a token-level rewrite applied to every token
+// from the scanner before it is parsed.
+func withTokenTransform(fn func(Token) []Token) compilerOption {
+	return func(c *compiler) {
+		c.transform = fn
+	}
+}
+
+// withScannerOptions passes opts, such as a maximum token length, to the
+// scanner the compiler reads its source through.
+func withScannerOptions(opts ...scannerOption) compilerOption {
+	return func(c *compiler) {
+		c.scannerOpts = append(c.scannerOpts, opts...)
+	}
 }
 
-func newCompiler() Compiler {
-	c := &compiler{}
+func newCompiler(opts ...compilerOption) Compiler {
+	c := &compiler{maxDepth: defaultMaxDepth}
+	for _, opt := range opts {
+		opt(c)
+	}
 	c.parseRules = map[TokenType]parseRule{
-		TokenEOF:        {nil, nil, precNone},
-		TokenNil:        {c.literal, nil, precNone},
-		TokenFalse:      {c.literal, nil, precNone},
-		TokenTrue:       {c.literal, nil, precNone},
-		TokenLeftParen:  {c.grouping, nil, precNone},
-		TokenRightParen: {nil, nil, precNone},
-		TokenPlus:       {c.unary, c.binary, precTerm},
-		TokenMinus:      {c.unary, c.binary, precTerm},
-		TokenStar:       {c.unary, c.binary, precFactor},
-		TokenSlash:      {c.unary, c.binary, precFactor},
-		TokenEqualEqual: {nil, c.binary, precEquality},
-		TokenGreater:    {nil, c.binary, precComparison},
-		TokenLess:       {nil, c.binary, precComparison},
-		TokenBang:       {c.unary, nil, precNone},
-		TokenNumber:     {c.number, nil, precNone},
+		TokenEOF:              {nil, nil, precNone},
+		TokenNil:              {c.literal, nil, precNone},
+		TokenFalse:            {c.literal, nil, precNone},
+		TokenTrue:             {c.literal, nil, precNone},
+		TokenLeftParen:        {c.grouping, nil, precNone},
+		TokenRightParen:       {nil, nil, precNone},
+		TokenRightBrace:       {nil, nil, precNone},
+		TokenPlus:             {c.unary, c.binary, precTerm},
+		TokenMinus:            {c.unary, c.binary, precTerm},
+		TokenStar:             {c.unary, c.binary, precFactor},
+		TokenSlash:            {c.unary, c.binary, precFactor},
+		TokenStarStar:         {nil, c.binary, precPower},
+		TokenEqualEqual:       {nil, c.binary, precEquality},
+		TokenBangEqual:        {nil, c.binary, precEquality},
+		TokenGreater:          {nil, c.binary, precComparison},
+		TokenLess:             {nil, c.binary, precComparison},
+		TokenLessEqualGreater: {nil, c.binary, precComparison},
+		TokenBang:             {c.unary, nil, precNone},
+		TokenNumber:           {c.number, nil, precNone},
+		TokenLine:             {c.currentLine, nil, precNone},
 	}
 	return c
 }
 
 func (c *compiler) advance() {
 	c.previous = c.current
-	c.current = c.scanner.nextToken()
+	c.current = c.nextToken()
+}
+
+// nextToken returns the next token to parse, passing scanned tokens
+// through the transform when one is installed.
+func (c *compiler) nextToken() Token {
+	if c.transform == nil {
+		return c.scanner.nextToken()
+	}
+
+	for len(c.pending) == 0 {
+		t := c.scanner.nextToken()
+		c.pending = c.transform(t)
+
+		// never let a transform swallow the end of input
+		if t.typ == TokenEOF && len(c.pending) == 0 {
+			return t
+		}
+	}
+
+	t := c.pending[0]
+	c.pending = c.pending[1:]
+	return t
 }
 
 func (c *compiler) consume(typ TokenType) error {
 	if c.current.typ != typ {
-		return fmt.Errorf("expected %v, got %v", typ, c.current.typ)
+		if c.current.typ == TokenEOF {
+			return c.errorAt(c.current, "expected %s but reached end of input", typ.describe())
+		}
+		return c.errorAt(c.current, "expected %s, got %s", typ.describe(), c.current.describe())
 	}
 	c.advance()
 	return nil
 }
 
+// CompileError reports a problem found while compiling, positioned at the
+// token where it was detected.
+type CompileError struct {
+	name   string
+	line   int
+	column int
+	msg    string
+}
+
+func (e *CompileError) Error() string {
+	return fmt.Sprintf("%s: %s", sourcePosition(e.name, e.line), e.msg)
+}
+
+// errorAt formats a compile error positioned at t.
+func (c *compiler) errorAt(t Token, format string, args ...interface{}) error {
+	return &CompileError{
+		name:   c.name,
+		line:   t.line,
+		column: t.column,
+		msg:    fmt.Sprintf(format, args...),
+	}
+}
+
+// sourcePosition renders a line, prefixed with the source name if known,
+// as it appears at the start of error messages.
+func sourcePosition(name string, line int) string {
+	if name == "" {
+		return fmt.Sprint(line)
+	}
+	return fmt.Sprintf("%s:%d", name, line)
+}
+
 func (c *compiler) compile(source string) (*Chunk, error) {
-	chunk := &Chunk{}
-	c.scanner = newScanner(source)
+	chunk := &Chunk{name: c.name}
+	c.scanner = newScanner(source, c.scannerOpts...)
+	c.pending = nil
 
 	c.advance()
 
@@ -86,7 +204,7 @@ loop:
 		t := c.current
 		switch t.typ {
 		case TokenError:
-			return nil, fmt.Errorf("%d: %s", t.line, t.data)
+			return nil, c.errorAt(t, "%s", t.data)
 		case TokenEOF:
 			break loop
 		default:
@@ -96,22 +214,54 @@ loop:
 		}
 	}
 
-	chunk.addOp(OpReturn)
+	chunk.addOp(OpReturn, c.current.line, c.current.column)
+	optimize(chunk)
+
+	return chunk, nil
+}
+
+// compileExpression compiles source as a single expression without the
+// trailing OpReturn, leaving its value on the stack for the caller.
+func (c *compiler) compileExpression(source string) (*Chunk, error) {
+	chunk := &Chunk{name: c.name}
+	c.scanner = newScanner(source, c.scannerOpts...)
+	c.pending = nil
+
+	c.advance()
+
+	if err := c.expression(chunk); err != nil {
+		return nil, err
+	}
+	if err := c.consume(TokenEOF); err != nil {
+		return nil, err
+	}
+	optimize(chunk)
 
 	return chunk, nil
 }
 
 func (c *compiler) parse(chunk *Chunk, prec precedence) error {
+	c.depth++
+	defer func() { c.depth-- }()
+
+	if c.depth > c.maxDepth {
+		return c.errorAt(c.current, "expression too deeply nested")
+	}
+
 	c.advance()
 
-	rule, err := c.getParseRule(c.previous.typ)
+	rule, err := c.getParseRule(c.previous)
 	if err != nil {
 		return err
 	}
 
 	prefix := rule.prefix
 	if prefix == nil {
-		return fmt.Errorf("syntax error")
+		switch t := c.previous; t.typ {
+		case TokenRightParen, TokenRightBrace:
+			return c.errorAt(t, "unexpected '%s'", t.data)
+		}
+		return c.errorAt(c.previous, "expected expression, got %s", c.previous.describe())
 	}
 
 	if err = prefix(chunk); err != nil {
@@ -119,7 +269,7 @@ func (c *compiler) parse(chunk *Chunk, prec precedence) error {
 	}
 
 	for {
-		rule, err = c.getParseRule(c.current.typ)
+		rule, err = c.getParseRule(c.current)
 		if err != nil {
 			return err
 		}
@@ -138,10 +288,13 @@ func (c *compiler) parse(chunk *Chunk, prec precedence) error {
 	return nil
 }
 
-func (c *compiler) getParseRule(typ TokenType) (*parseRule, error) {
-	rule, ok := c.parseRules[typ]
+func (c *compiler) getParseRule(t Token) (*parseRule, error) {
+	rule, ok := c.parseRules[t.typ]
 	if !ok {
-		return nil, fmt.Errorf("unknown token type: %s", typ)
+		if t.typ == TokenError {
+			return nil, c.errorAt(t, "%s", t.data)
+		}
+		return nil, c.errorAt(t, "unexpected %s", t.describe())
 	}
 	return &rule, nil
 }
@@ -157,31 +310,61 @@ var literalOps = map[TokenType]Op{
 }
 
 func (c *compiler) literal(chunk *Chunk) error {
-	typ := c.previous.typ
+	t := c.previous
 
-	op, ok := literalOps[typ]
+	op, ok := literalOps[t.typ]
 	if !ok {
-		return fmt.Errorf("unknown literal token: %s", typ)
+		return c.errorAt(t, "unknown literal token: %s", t.typ.describe())
 	}
-	chunk.addOp(op)
+	chunk.addOp(op, t.line, t.column)
 	return nil
 }
 
 func (c *compiler) number(chunk *Chunk) error {
-	f, err := strconv.ParseFloat(c.previous.data, 64)
+	t := c.previous
+
+	f, err := parseNumber(t.data)
 	if err != nil {
-		return err
+		return c.errorAt(t, "invalid number literal '%s'", truncateLexeme(t.data))
+	}
+
+	return c.constant(chunk, numberValue(f), t)
+}
+
+// numberBases maps the second character of a prefixed integer literal to
+// its base.
+var numberBases = map[byte]int{
+	'x': 16, 'X': 16,
+	'o': 8, 'O': 8,
+	'b': 2, 'B': 2,
+}
+
+// parseNumber parses a decimal literal, or a hex, octal or binary integer
+// literal introduced by 0x, 0o or 0b.
+func parseNumber(lexeme string) (float64, error) {
+	if len(lexeme) > 2 && lexeme[0] == '0' {
+		if base, ok := numberBases[lexeme[1]]; ok {
+			n, err := strconv.ParseUint(lexeme[2:], base, 64)
+			return float64(n), err
+		}
 	}
+	return strconv.ParseFloat(lexeme, 64)
+}
 
-	val := numberValue(f)
+// currentLine compiles __line__ into the number of the line it appears on.
+func (c *compiler) currentLine(chunk *Chunk) error {
+	t := c.previous
+	return c.constant(chunk, numberValue(float64(t.line)), t)
+}
 
+func (c *compiler) constant(chunk *Chunk, val Value, t Token) error {
 	index := chunk.addVal(val)
 	if index > 255 {
-		return fmt.Errorf("too many constants")
+		return c.errorAt(t, "too many constants")
 	}
 
-	chunk.addOp(OpConstant)
-	chunk.addByte(byte(index))
+	chunk.addOp(OpConstant, t.line, t.column)
+	chunk.addByte(byte(index), t.line, t.column)
 
 	return nil
 }
@@ -199,7 +382,8 @@ var unaryOps = map[TokenType]Op{
 }
 
 func (c *compiler) unary(chunk *Chunk) error {
-	typ := c.previous.typ
+	t := c.previous
+	typ := t.typ
 
 	if err := c.parse(chunk, precUnary); err != nil {
 		return err
@@ -207,40 +391,52 @@ func (c *compiler) unary(chunk *Chunk) error {
 
 	op, ok := unaryOps[typ]
 	if !ok {
-		return fmt.Errorf("unknown unary op: %s", typ)
+		return c.errorAt(t, "unknown unary op: %s", typ.describe())
 	}
-	chunk.addOp(op)
+	chunk.addOp(op, t.line, t.column)
 
 	return nil
 }
 
-var binaryOps = map[TokenType]Op{
-	TokenPlus:       OpAdd,
-	TokenMinus:      OpSubtract,
-	TokenStar:       OpMultiply,
-	TokenSlash:      OpDivide,
-	TokenEqualEqual: OpEqual,
-	TokenGreater:    OpGreater,
-	TokenLess:       OpLess,
+var binaryOps = map[TokenType][]Op{
+	TokenPlus:             {OpAdd},
+	TokenMinus:            {OpSubtract},
+	TokenStar:             {OpMultiply},
+	TokenSlash:            {OpDivide},
+	TokenStarStar:         {OpPower},
+	TokenEqualEqual:       {OpEqual},
+	TokenBangEqual:        {OpEqual, OpNot},
+	TokenGreater:          {OpGreater},
+	TokenLess:             {OpLess},
+	TokenLessEqualGreater: {OpCompare},
 }
 
 func (c *compiler) binary(chunk *Chunk) error {
-	typ := c.previous.typ
+	t := c.previous
+	typ := t.typ
 
-	rule, err := c.getParseRule(typ)
+	rule, err := c.getParseRule(t)
 	if err != nil {
 		return err
 	}
 
-	if err := c.parse(chunk, rule.precedence+1); err != nil {
+	// ** is right-associative, so its right operand may itself be a **
+	prec := rule.precedence + 1
+	if typ == TokenStarStar {
+		prec = rule.precedence
+	}
+
+	if err := c.parse(chunk, prec); err != nil {
 		return err
 	}
 
-	op, ok := binaryOps[typ]
+	ops, ok := binaryOps[typ]
 	if !ok {
-		return fmt.Errorf("unknown binary op: %s", typ)
+		return c.errorAt(t, "unknown binary op: %s", typ.describe())
+	}
+	for _, op := range ops {
+		chunk.addOp(op, t.line, t.column)
 	}
-	chunk.addOp(op)
 
 	return nil
 }
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// disassemble renders chunk's instructions on one line, constants by
+// their value and everything else by name.
+func disassemble(chunk *Chunk) string {
+	var parts []string
+	for offset := 0; offset < len(chunk.code); offset++ {
+		op := Op(chunk.code[offset])
+		if op == OpConstant {
+			offset++
+			parts = append(parts, formatConstant(chunk.vals[chunk.code[offset]]))
+			continue
+		}
+		parts = append(parts, fmt.Sprint(op))
+	}
+	return strings.Join(parts, " ")
+}
+
+func compileListing(t *testing.T, source string) string {
+	t.Helper()
+	chunk, err := newCompiler().compile(source)
+	if err != nil {
+		t.Fatalf("%s: %s", source, err)
+	}
+	return disassemble(chunk)
+}
+
+// compileError returns the message of the error from compiling source,
+// or "" if it compiles.
+func compileError(source string, opts ...compilerOption) string {
+	if _, err := newCompiler(opts...).compile(source); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+func TestNegativeLiteralFolds(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"-5", "-5 OpReturn"},
+		{"1 - 5", "1 5 OpSubtract OpReturn"},
+		{"1 - -5", "1 -5 OpSubtract OpReturn"},
+	}
+
+	for _, test := range tests {
+		if got := compileListing(t, test.source); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.source, got, test.want)
+		}
+	}
+}
+
+func TestDeepNesting(t *testing.T) {
+	source := strings.Repeat("(", 10000) + "1" + strings.Repeat(")", 10000)
+
+	_, err := newCompiler().compile(source)
+	if err == nil || !strings.HasSuffix(err.Error(), "expression too deeply nested") {
+		t.Errorf("got error %v, want expression too deeply nested", err)
+	}
+}
+
+func TestMaxDepth(t *testing.T) {
+	c := newCompiler(withMaxDepth(3))
+
+	if _, err := c.compile("((1))"); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	_, err := c.compile("(((1)))")
+	if err == nil || !strings.HasSuffix(err.Error(), "expression too deeply nested") {
+		t.Errorf("got error %v, want expression too deeply nested", err)
+	}
+}
+
+func TestInvalidNumberLiteral(t *testing.T) {
+	_, err := newCompiler().compile("1 +\n0b102")
+	if err == nil || err.Error() != "2: invalid number literal '0b102'" {
+		t.Errorf("got error %v, want invalid number literal on line 2", err)
+	}
+}
+
+func TestStrayClosers(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"1 + 2)", "1: unexpected ')'"},
+		{"}", "1: unexpected '}'"},
+		{"1 +\n)", "2: unexpected ')'"},
+		{"(1 + 2))", "1: unexpected ')'"},
+	}
+
+	for _, test := range tests {
+		if got := compileError(test.source); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.source, got, test.want)
+		}
+	}
+}
+
+// TestPrecedence checks the grouping of every precedence level that has
+// operators: equality, comparison, term, factor, unary and power. The
+// assignment, or, and and call levels have none yet.
+func TestPrecedence(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"1 + 2 * 3", "1 2 3 OpMultiply OpAdd"},
+		{"1 * 2 + 3", "1 2 OpMultiply 3 OpAdd"},
+		{"(1 + 2) * 3", "1 2 OpAdd 3 OpMultiply"},
+		{"1 - 2 - 3", "1 2 OpSubtract 3 OpSubtract"},
+		{"1 / 2 * 3", "1 2 OpDivide 3 OpMultiply"},
+		{"-1 + 2", "-1 2 OpAdd"},
+		{"-(1 + 2)", "1 2 OpAdd OpNegate"},
+		{"2 * -3", "2 -3 OpMultiply"},
+		{"!true == false", "OpFalse OpFalse OpEqual"},
+		{"!(1 == 2)", "1 2 OpEqual OpNot"},
+		{"1 + 2 > 3 * 4", "1 2 OpAdd 3 4 OpMultiply OpGreater"},
+		{"1 < 2 == true", "1 2 OpLess OpTrue OpEqual"},
+		{"1 == 2 != true", "1 2 OpEqual OpTrue OpEqual OpNot"},
+		{"1 + 2 <=> 3", "1 2 OpAdd 3 OpCompare"},
+		{"1 <=> 2 == 0", "1 2 OpCompare 0 OpEqual"},
+		{"2 ** 3 ** 2", "2 3 2 OpPower OpPower"},
+		{"2 * 3 ** 2", "2 3 2 OpPower OpMultiply"},
+		{"-2 ** 2", "2 2 OpPower OpNegate"},
+	}
+
+	for _, test := range tests {
+		chunk, err := newCompiler().compileExpression(test.source)
+		if err != nil {
+			t.Errorf("%s: %s", test.source, err)
+			continue
+		}
+		if got := disassemble(chunk); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.source, got, test.want)
+		}
+	}
+}
+
+func TestNumberParsingIgnoresLocale(t *testing.T) {
+	if tok := scanAll("1,5")[1]; tok.typ != TokenComma {
+		t.Errorf("got %v, want the comma to scan as TokenComma", tok.typ)
+	}
+	if got := compileError("1,5"); got != "1: unexpected ','" {
+		t.Errorf("1,5: got %q, want a syntax error", got)
+	}
+
+	chunk, err := newCompiler().compile("1.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunk.vals) != 1 || chunk.vals[0] != numberValue(1.5) {
+		t.Errorf("1.5: got constants %v, want [1.5]", chunk.vals)
+	}
+}
+
+func TestCompileIsReproducible(t *testing.T) {
+	programs := []string{
+		"1 + 2 * 3",
+		"-(4 / 5) ** 2 <=> 0x1F",
+		"!true == (nil != false)",
+		"1 +\n  2 -\n  __line__",
+	}
+
+	for _, source := range programs {
+		first, err := newCompiler().compile(source)
+		if err != nil {
+			t.Fatalf("%s: %s", source, err)
+		}
+		second, err := newCompiler().compile(source)
+		if err != nil {
+			t.Fatalf("%s: %s", source, err)
+		}
+
+		if !reflect.DeepEqual(first.code, second.code) || !reflect.DeepEqual(first.vals, second.vals) {
+			t.Errorf("%s: compiled differently:\n%v %v\n%v %v", source, first.code, first.vals, second.code, second.vals)
+		}
+	}
+}
+
+func TestCompileExpressionOmitsReturn(t *testing.T) {
+	chunk, err := newCompiler().compileExpression("1+2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := disassemble(chunk); got != "1 2 OpAdd" {
+		t.Errorf("got %s, want the chunk to end with OpAdd", got)
+	}
+
+	v, err := eval(t, "1+2")
+	if err != nil || v != numberValue(3) {
+		t.Errorf("got %v, %v, want 3 left on the stack", v, err)
+	}
+
+	if _, err := newCompiler().compileExpression("1 2"); err == nil || err.Error() != "1: expected end of input, got number '2'" {
+		t.Errorf("got error %v, want trailing input rejected", err)
+	}
+}
+
+func TestLineBuiltin(t *testing.T) {
+	v, err := eval(t, "\n\n\n\n\n\n__line__")
+	if err != nil || v != numberValue(7) {
+		t.Errorf("got %v, %v, want 7", v, err)
+	}
+
+	if got := compileListing(t, "__line__ +\n  __line__"); got != "1 2 OpAdd OpReturn" {
+		t.Errorf("got %s", got)
+	}
+}
+
+func TestUnterminatedGrouping(t *testing.T) {
+	if got := compileError("(1 + 2"); got != "1: expected ')' but reached end of input" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestPrefixedLiterals(t *testing.T) {
+	values := []struct {
+		source string
+		want   float64
+	}{
+		{"0x1F", 31},
+		{"0XfF", 255},
+		{"0o17", 15},
+		{"0O7", 7},
+		{"0b1010", 10},
+		{"0B1", 1},
+	}
+	for _, test := range values {
+		v, err := eval(t, test.source)
+		if err != nil || v != numberValue(test.want) {
+			t.Errorf("%s: got %v, %v, want %v", test.source, v, err, test.want)
+		}
+	}
+
+	for _, source := range []string{"0x1G", "0o18", "0b102", "0x"} {
+		want := fmt.Sprintf("1: invalid number literal '%s'", source)
+		if got := compileError(source); got != want {
+			t.Errorf("%s: got %q, want %q", source, got, want)
+		}
+	}
+}
+
+func TestSourceName(t *testing.T) {
+	if got := compileError("1 +\n)", withSourceName("prog.lox")); got != "prog.lox:2: unexpected ')'" {
+		t.Errorf("got %q", got)
+	}
+	if got := compileError("1 +\n)"); got != "2: unexpected ')'" {
+		t.Errorf("without a name: got %q", got)
+	}
+}
+
+func TestTokenTransform(t *testing.T) {
+	// yes and no stand in for true and false, and twice expands to 2 *
+	transform := func(tok Token) []Token {
+		switch tok.data {
+		case "yes":
+			tok.typ = TokenTrue
+		case "no":
+			tok.typ = TokenFalse
+		case "twice":
+			return []Token{
+				{typ: TokenNumber, line: tok.line, column: tok.column, data: "2"},
+				{typ: TokenStar, line: tok.line, column: tok.column, data: "*"},
+			}
+		}
+		return []Token{tok}
+	}
+
+	tests := []struct {
+		source string
+		same   string
+	}{
+		{"!yes == no", "!true == false"},
+		{"twice 3", "2 * 3"},
+		{"twice twice 3", "2 * 2 * 3"},
+	}
+
+	for _, test := range tests {
+		chunk, err := newCompiler(withTokenTransform(transform)).compile(test.source)
+		if err != nil {
+			t.Errorf("%s: %s", test.source, err)
+			continue
+		}
+		if got, want := disassemble(chunk), compileListing(t, test.same); got != want {
+			t.Errorf("%s: got %s, want %s", test.source, got, want)
+		}
+	}
+}
+
+func TestTokenTransformKeepsEOF(t *testing.T) {
+	drop := func(Token) []Token { return nil }
+
+	if got := compileError("1 + 2", withTokenTransform(drop)); got != "" {
+		t.Errorf("got %q, want an empty program", got)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+// Debugger drives a VM one instruction at a time, exposing its state
+// between steps.
+type Debugger struct {
+	vm          *vm
+	breakpoints map[int]bool
+
+	// line and offset locate the last instruction executed; line is 0
+	// before the first step.
+	line   int
+	offset int
+
+	// paused is set when Continue stops at a breakpoint, so that the next
+	// Continue executes that instruction rather than stopping again.
+	paused bool
+}
+
+func newDebugger(chunk *Chunk) *Debugger {
+	vm := &vm{}
+	vm.reset(chunk)
+	return &Debugger{vm: vm, breakpoints: map[int]bool{}}
+}
+
+// SetBreakpoint makes Continue pause before the first instruction of line.
+func (d *Debugger) SetBreakpoint(line int) {
+	d.breakpoints[line] = true
+}
+
+// Continue runs until execution reaches a breakpoint line, the program
+// finishes, or an error occurs. It pauses before the next instruction
+// executes, including the program's first, when control enters the line
+// either from a different one or by jumping backwards. Consecutive
+// instructions on a line pause once, but a loop written on a single line
+// pauses on every iteration.
+func (d *Debugger) Continue() (done bool, err error) {
+	for {
+		if !d.paused && d.vm.ip < len(d.vm.chunk.code) {
+			line, _ := d.vm.chunk.positionAt(d.vm.ip)
+			if d.breakpoints[line] && (line != d.line || d.vm.ip <= d.offset) {
+				d.paused = true
+				return false, nil
+			}
+		}
+
+		done, err = d.Step()
+		if done || err != nil {
+			return done, err
+		}
+	}
+}
+
+// Step executes a single instruction. done is true once the program has
+// finished; further calls are no-ops.
+func (d *Debugger) Step() (done bool, err error) {
+	defer d.vm.recoverPanic(&err)
+
+	d.paused = false
+	if d.vm.ip < len(d.vm.chunk.code) {
+		d.line, _ = d.vm.chunk.positionAt(d.vm.ip)
+		d.offset = d.vm.ip
+	}
+
+	done, err = d.vm.step()
+	if err != nil {
+		return done, d.vm.runtimeError(err)
+	}
+	return done, nil
+}
+
+// StackSnapshot returns a copy of the VM's value stack, bottom first.
+func (d *Debugger) StackSnapshot() []Value {
+	vals := make([]Value, len(d.vm.stack.vals))
+	copy(vals, d.vm.stack.vals)
+	return vals
+}
+
+// CurrentOffset returns the offset of the next instruction to execute.
+func (d *Debugger) CurrentOffset() int {
+	return d.vm.ip
+}
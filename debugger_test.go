@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func newTestDebugger(t *testing.T, source string) *Debugger {
+	t.Helper()
+	chunk, err := newCompiler().compile(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newDebugger(chunk)
+}
+
+func TestDebuggerStep(t *testing.T) {
+	d := newTestDebugger(t, "1 + 2 * 3")
+
+	steps := []struct {
+		offset int
+		stack  string
+	}{
+		{2, "[1.000000]"},
+		{4, "[1.000000 2.000000]"},
+		{6, "[1.000000 2.000000 3.000000]"},
+		{7, "[1.000000 6.000000]"},
+		{8, "[7.000000]"},
+	}
+
+	for _, step := range steps {
+		done, err := d.Step()
+		if done || err != nil {
+			t.Fatalf("step to %d: done %v, err %v", step.offset, done, err)
+		}
+		if offset := d.CurrentOffset(); offset != step.offset {
+			t.Errorf("got offset %d, want %d", offset, step.offset)
+		}
+		if stack := fmt.Sprint(d.StackSnapshot()); stack != step.stack {
+			t.Errorf("at %d: got stack %s, want %s", step.offset, stack, step.stack)
+		}
+	}
+
+	var done bool
+	var err error
+	out := capture(t, &os.Stdout, func() {
+		done, err = d.Step()
+	})
+	if !done || err != nil {
+		t.Errorf("OpReturn: done %v, err %v", done, err)
+	}
+	if out != "7.000000\n" {
+		t.Errorf("printed %q, want the result", out)
+	}
+
+	if done, err := d.Step(); !done || err != nil {
+		t.Errorf("step after return: done %v, err %v", done, err)
+	}
+}
+
+func TestDebuggerBreakpoints(t *testing.T) {
+	// the instructions run on lines 1, 2, 3, 2, 1, 3
+	d := newTestDebugger(t, "1 +\n2 *\n3")
+	d.SetBreakpoint(1)
+	d.SetBreakpoint(3)
+
+	for _, offset := range []int{0, 4, 7, 8} {
+		done, err := d.Continue()
+		if done || err != nil {
+			t.Fatalf("continue to %d: done %v, err %v", offset, done, err)
+		}
+		if got := d.CurrentOffset(); got != offset {
+			t.Errorf("paused at %d, want %d", got, offset)
+		}
+	}
+
+	var done bool
+	var err error
+	capture(t, &os.Stdout, func() {
+		done, err = d.Continue()
+	})
+	if !done || err != nil {
+		t.Errorf("final continue: done %v, err %v", done, err)
+	}
+	if stack := d.StackSnapshot(); len(stack) != 0 {
+		t.Errorf("stack not empty after return: %v", stack)
+	}
+}
+
+func TestDebuggerContinueAfterStep(t *testing.T) {
+	// everything after the first instruction is on line 2
+	d := newTestDebugger(t, "1\n+ 2")
+	d.SetBreakpoint(2)
+
+	if done, err := d.Continue(); done || err != nil || d.CurrentOffset() != 2 {
+		t.Fatalf("got done %v, err %v at %d, want pause at 2", done, err, d.CurrentOffset())
+	}
+	if _, err := d.Step(); err != nil {
+		t.Fatal(err)
+	}
+
+	var done bool
+	capture(t, &os.Stdout, func() {
+		done, _ = d.Continue()
+	})
+	if !done {
+		t.Errorf("paused at %d, want to run to the end", d.CurrentOffset())
+	}
+}
+
+func TestDebuggerBreakpointOnBackwardJump(t *testing.T) {
+	// there are no jump instructions yet, so rewind ip by hand as a loop
+	// on a single line would
+	d := newTestDebugger(t, "1 + 2")
+	d.SetBreakpoint(1)
+
+	if done, err := d.Continue(); done || err != nil || d.CurrentOffset() != 0 {
+		t.Fatalf("got done %v, err %v at %d, want pause at 0", done, err, d.CurrentOffset())
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := d.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d.vm.ip = 0
+	if done, err := d.Continue(); done || err != nil || d.CurrentOffset() != 0 {
+		t.Errorf("got done %v, err %v at %d, want pause at 0 again", done, err, d.CurrentOffset())
+	}
+}
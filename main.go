@@ -2,18 +2,44 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"strings"
+	"time"
+)
+
+// maxSourceSize caps how many bytes of source are read from a file or a
+// single REPL line.
+const maxSourceSize = 64 << 20
+
+var errSourceTooLarge = errors.New("source exceeds maximum size")
+
+var (
+	reportTimes = flag.Bool("time", false, "print compile and run durations to stderr")
+	printTokens = flag.Bool("tokens", false, "print the token stream and exit without compiling")
 )
 
 func main() {
-	args := os.Args[1:]
+	flag.Parse()
+	args := flag.Args()
 	switch len(args) {
 	case 0:
-		repl()
+		repl(os.Stdin, maxSourceSize)
 	case 1:
-		if err := runFile(args[0]); err != nil {
+		if err := checkSourceFile(args[0]); err != nil {
+			fmt.Fprintf(os.Stderr, "glox: %s\n", err)
+			os.Exit(1)
+		}
+		run := runFile
+		if *printTokens {
+			run = tokenizeFile
+		}
+		if err := run(args[0]); err != nil {
 			fmt.Printf("error: %s\n", err)
 		}
 	default:
@@ -21,30 +47,199 @@ func main() {
 	}
 }
 
-func repl() {
-	scanner := bufio.NewScanner(os.Stdin)
+// repl reads and runs lines from in until it is exhausted. A line, or a
+// program joined from several lines, longer than limit bytes is reported
+// and dropped.
+func repl(in io.Reader, limit int) {
+	reader := bufio.NewReader(in)
+
+	// a line ending in a backslash is joined with the next one before
+	// the whole buffer is compiled; after :paste, every line is joined
+	// until a blank one. size is the length of the joined buffer so far.
+	var pending []string
+	size := 0
+	pasting := false
+
 	for {
-		fmt.Print("> ")
-		if scanner.Scan() {
-			if err := interpret(scanner.Text()); err != nil {
-				fmt.Printf("error: %s\n", err)
+		if len(pending) > 0 || pasting {
+			fmt.Print("... ")
+		} else {
+			fmt.Print("> ")
+		}
+
+		line, err := readLine(reader, limit)
+		if err == io.EOF && len(pending) > 0 {
+			// input ended mid-paste or after a trailing backslash; run
+			// what was gathered rather than silently dropping it
+			line, err, pasting = "", nil, false
+		}
+		if err == nil && size+len(line) > limit {
+			err = errSourceTooLarge
+		}
+		if err == errSourceTooLarge {
+			fmt.Printf("error: %s\n", err)
+			pending, size, pasting = nil, 0, false
+			continue
+		}
+		if err != nil {
+			return
+		}
+
+		if line == ":paste" && len(pending) == 0 && !pasting {
+			pasting = true
+			continue
+		}
+		if pasting {
+			if line != "" {
+				pending = append(pending, line)
+				size += len(line) + 1
+				continue
 			}
+			pasting = false
+		} else if strings.HasSuffix(line, "\\") {
+			line = strings.TrimSuffix(line, "\\")
+			pending = append(pending, line)
+			size += len(line) + 1
+			continue
 		}
+
+		source := strings.Join(append(pending, line), "\n")
+		pending, size = nil, 0
+
+		run := interpret
+		if expr := strings.TrimPrefix(source, ":time "); expr != source {
+			source, run = expr, timeExpression
+		}
+
+		if err := run("<repl>", source); err != nil {
+			fmt.Printf("error: %s\n", err)
+		}
+	}
+}
+
+// readLine reads the next line from r without its line ending. A line
+// longer than limit bytes is consumed but only buffered up to the limit,
+// and errSourceTooLarge is returned in its place.
+func readLine(r *bufio.Reader, limit int) (string, error) {
+	var line []byte
+	for {
+		frag, err := r.ReadSlice('\n')
+		if len(line) <= limit {
+			line = append(line, frag...)
+		}
+
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil && (err != io.EOF || len(line) == 0) {
+			return "", err
+		}
+		break
+	}
+
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	line = bytes.TrimSuffix(line, []byte("\r"))
+	if len(line) > limit {
+		return "", errSourceTooLarge
+	}
+	return string(line), nil
+}
+
+// checkSourceFile rejects paths that exist but cannot be read as a
+// program, such as directories, before they reach runFile.
+func checkSourceFile(filename string) error {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("'%s' is a directory, expected a file", filename)
 	}
+	if !info.Mode().IsRegular() && info.Mode()&os.ModeNamedPipe == 0 {
+		return fmt.Errorf("'%s' is not a regular file", filename)
+	}
+	return nil
 }
 
 func runFile(filename string) error {
-	source, err := ioutil.ReadFile(filename)
+	source, err := readSourceFile(filename)
+	if err != nil {
+		return err
+	}
+	return interpret(filename, source)
+}
+
+func tokenizeFile(filename string) error {
+	source, err := readSourceFile(filename)
+	if err != nil {
+		return err
+	}
+	dumpTokens(source)
+	return nil
+}
+
+func readSourceFile(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return readSource(f, maxSourceSize)
+}
+
+// readSource reads all of r, failing with errSourceTooLarge rather than
+// buffering more than limit bytes.
+func readSource(r io.Reader, limit int64) (string, error) {
+	source, err := ioutil.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return "", err
+	}
+	if int64(len(source)) > limit {
+		return "", errSourceTooLarge
+	}
+	return string(source), nil
+}
+
+// timeIterations is how many times the REPL's :time command evaluates
+// its expression.
+const timeIterations = 1000
+
+// timeExpression compiles source once, evaluates it timeIterations times
+// without tracing, and reports the mean time per evaluation.
+func timeExpression(name, source string) error {
+	chunk, err := newCompiler(withSourceName(name)).compileExpression(source)
 	if err != nil {
 		return err
 	}
-	return interpret(string(source))
+
+	vm := &vm{}
+	start := time.Now()
+	for i := 0; i < timeIterations; i++ {
+		if err := vm.run(chunk); err != nil {
+			return err
+		}
+	}
+	elapsed := time.Since(start)
+
+	fmt.Printf("%s per run (%d runs)\n", elapsed/timeIterations, timeIterations)
+	return nil
 }
 
-func interpret(source string) error {
-	chunk, err := newCompiler().compile(source)
+func interpret(name, source string) error {
+	start := time.Now()
+	chunk, err := newCompiler(withSourceName(name)).compile(source)
+	if *reportTimes {
+		fmt.Fprintf(os.Stderr, "compile: %s\n", time.Since(start))
+	}
 	if err != nil {
 		return err
 	}
-	return newVM().run(chunk)
+
+	start = time.Now()
+	err = newVM().run(chunk)
+	if *reportTimes {
+		fmt.Fprintf(os.Stderr, "run: %s\n", time.Since(start))
+	}
+
+	return err
 }
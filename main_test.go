@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withReportTimes runs fn with the -time flag set.
+func withReportTimes(fn func()) {
+	saved := *reportTimes
+	*reportTimes = true
+	defer func() {
+		*reportTimes = saved
+	}()
+	fn()
+}
+
+var durationLine = regexp.MustCompile(`(?m)^(compile|run): (\S+)$`)
+
+// reportedTimes parses the durations interpret writes to stderr, keyed
+// by phase.
+func reportedTimes(t *testing.T, stderr string) map[string]time.Duration {
+	t.Helper()
+	times := map[string]time.Duration{}
+	for _, m := range durationLine.FindAllStringSubmatch(stderr, -1) {
+		d, err := time.ParseDuration(m[2])
+		if err != nil {
+			t.Fatalf("%s: %s", m[0], err)
+		}
+		times[m[1]] = d
+	}
+	return times
+}
+
+func TestReportTimes(t *testing.T) {
+	var err error
+	stderr := capture(t, &os.Stderr, func() {
+		capture(t, &os.Stdout, func() {
+			withReportTimes(func() {
+				err = interpret("test", "1 + 2")
+			})
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	times := reportedTimes(t, stderr)
+	for _, phase := range []string{"compile", "run"} {
+		d, ok := times[phase]
+		if !ok {
+			t.Errorf("no %s time in %q", phase, stderr)
+		} else if d < 0 {
+			t.Errorf("negative %s time %s", phase, d)
+		}
+	}
+}
+
+func TestReportTimesOnCompileError(t *testing.T) {
+	var err error
+	stderr := capture(t, &os.Stderr, func() {
+		withReportTimes(func() {
+			err = interpret("test", "1 +")
+		})
+	})
+	if err == nil {
+		t.Fatal("expected a compile error")
+	}
+
+	times := reportedTimes(t, stderr)
+	if _, ok := times["compile"]; !ok {
+		t.Errorf("no compile time in %q", stderr)
+	}
+	if _, ok := times["run"]; ok {
+		t.Errorf("run time reported for a program that never ran")
+	}
+}
+
+func TestCheckSourceFile(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "prog.lox")
+	if err := os.WriteFile(file, []byte("1 + 2"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checkSourceFile(file); err != nil {
+		t.Errorf("%s: %s", file, err)
+	}
+
+	want := fmt.Sprintf("'%s' is a directory, expected a file", dir)
+	if err := checkSourceFile(dir); err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %s", err, want)
+	}
+
+	if err := checkSourceFile(filepath.Join(dir, "missing.lox")); !os.IsNotExist(err) {
+		t.Errorf("got error %v, want not exist", err)
+	}
+}
+
+func TestTokenizeFile(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{
+			"1 +\n\t@ (2)",
+			"   1:1   TokenNumber        '1'\n" +
+				"   1:3   TokenPlus          '+'\n" +
+				"   2:2   TokenError         'unexpected character'\n" +
+				"   2:4   TokenLeftParen     '('\n" +
+				"   2:5   TokenNumber        '2'\n" +
+				"   2:6   TokenRightParen    ')'\n" +
+				"   2:7   TokenEOF           ''\n",
+		},
+		{
+			"// café\n1 + \"né\" @ 2",
+			"   2:1   TokenNumber        '1'\n" +
+				"   2:3   TokenPlus          '+'\n" +
+				"   2:5   TokenString        '\"né\"'\n" +
+				"   2:10  TokenError         'unexpected character'\n" +
+				"   2:12  TokenNumber        '2'\n" +
+				"   2:13  TokenEOF           ''\n",
+		},
+	}
+
+	for _, test := range tests {
+		file := filepath.Join(t.TempDir(), "prog.lox")
+		if err := os.WriteFile(file, []byte(test.source), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		var err error
+		out := capture(t, &os.Stdout, func() {
+			err = tokenizeFile(file)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if out != test.want {
+			t.Errorf("%q: got\n%s\nwant\n%s", test.source, out, test.want)
+		}
+	}
+}
+
+// endless is a reader that never runs out of input.
+type endless struct{}
+
+func (endless) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 'x'
+	}
+	return len(p), nil
+}
+
+func TestReadSource(t *testing.T) {
+	if source, err := readSource(strings.NewReader("1 + 2"), 5); err != nil || source != "1 + 2" {
+		t.Errorf("at the limit: got %q, %v", source, err)
+	}
+	if _, err := readSource(strings.NewReader("1 + 23"), 5); err != errSourceTooLarge {
+		t.Errorf("over the limit: got %v, want %v", err, errSourceTooLarge)
+	}
+	if _, err := readSource(endless{}, 1<<20); err != errSourceTooLarge {
+		t.Errorf("endless input: got %v, want %v", err, errSourceTooLarge)
+	}
+}
+
+func TestReadLine(t *testing.T) {
+	long := strings.Repeat("x", 5000)
+	r := bufio.NewReader(strings.NewReader("one\r\n" + long + "\ntwo\n\nthree"))
+
+	want := []struct {
+		line string
+		err  error
+	}{
+		{"one", nil},
+		{"", errSourceTooLarge},
+		{"two", nil},
+		{"", nil},
+		{"three", nil},
+		{"", io.EOF},
+	}
+	for _, w := range want {
+		if line, err := readLine(r, 4096); line != w.line || err != w.err {
+			t.Errorf("got %q, %v, want %q, %v", line, err, w.line, w.err)
+		}
+	}
+}
+
+// replOutput runs the REPL over input and returns what it printed.
+func replOutput(t *testing.T, input string, limit int) string {
+	t.Helper()
+	return capture(t, &os.Stdout, func() {
+		repl(strings.NewReader(input), limit)
+	})
+}
+
+func TestReplDropsLongLines(t *testing.T) {
+	out := replOutput(t, strings.Repeat("1", 10000)+"\n1 + 2\n", 16)
+
+	if !strings.Contains(out, "error: source exceeds maximum size") {
+		t.Errorf("no size error in %q", out)
+	}
+	if !strings.HasSuffix(out, "3.000000\n> ") {
+		t.Errorf("the REPL did not carry on after the long line: %q", out)
+	}
+}
+
+func TestReplLineContinuation(t *testing.T) {
+	out := replOutput(t, "1 +\\\n2\n", maxSourceSize)
+
+	if strings.Contains(out, "error:") {
+		t.Errorf("continued lines failed: %q", out)
+	}
+	if n := strings.Count(out, "OpReturn"); n != 1 {
+		t.Errorf("ran %d programs, want the joined lines run as one", n)
+	}
+	if !strings.Contains(out, "... ") || !strings.HasSuffix(out, "3.000000\n> ") {
+		t.Errorf("got %q, want a continuation prompt and the sum", out)
+	}
+}
+
+var perRun = regexp.MustCompile(`(\S+) per run \(1000 runs\)`)
+
+func TestReplTimeCommand(t *testing.T) {
+	out := replOutput(t, ":time 1+2\n", maxSourceSize)
+
+	m := perRun.FindStringSubmatch(out)
+	if m == nil {
+		t.Fatalf("no timing line in %q", out)
+	}
+	d, err := time.ParseDuration(m[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d < 0 {
+		t.Errorf("negative duration %s", d)
+	}
+	if strings.Contains(out, "OpAdd") {
+		t.Errorf("timed runs were traced: %q", out)
+	}
+}
+
+func TestReplPasteMode(t *testing.T) {
+	out := replOutput(t, ":paste\n(1 +\n2)\n* 3\n\n4\n", maxSourceSize)
+
+	if strings.Contains(out, "error:") {
+		t.Errorf("pasted lines failed: %q", out)
+	}
+	if n := strings.Count(out, "OpReturn"); n != 2 {
+		t.Errorf("ran %d programs, want the paste and the line after it", n)
+	}
+	if !strings.Contains(out, "9.000000\n> ") || !strings.HasSuffix(out, "4.000000\n> ") {
+		t.Errorf("got %q, want the pasted program's result then the next line's", out)
+	}
+}
+
+func TestReplJoinedSourceLimit(t *testing.T) {
+	inputs := []string{
+		":paste\n1 + 1 + 1\n+ 1 + 1 + 1\n\n1 + 2\n",
+		"1 + 1 + 1 +\\\n1 + 1 + 1 +\\\n1\n1 + 2\n",
+	}
+
+	for _, input := range inputs {
+		out := replOutput(t, input, 16)
+
+		if !strings.Contains(out, "error: source exceeds maximum size") {
+			t.Errorf("%q: no size error in %q", input, out)
+		}
+		if !strings.HasSuffix(out, "3.000000\n> ") {
+			t.Errorf("%q: the REPL did not carry on after dropping the buffer: %q", input, out)
+		}
+	}
+}
+
+func TestReplRunsBufferAtEOF(t *testing.T) {
+	for _, input := range []string{":paste\n1 +\n2", ":paste\n1 +\n2\n", "1 + 2 \\\n"} {
+		out := replOutput(t, input, maxSourceSize)
+
+		if strings.Contains(out, "error:") || !strings.Contains(out, "3.000000\n") {
+			t.Errorf("%q: got %q, want the buffered program run", input, out)
+		}
+	}
+}
+
+func TestReplRecoversFromLexicalError(t *testing.T) {
+	out := replOutput(t, "\"unterminated\n1 + 2\n", maxSourceSize)
+
+	if !strings.Contains(out, "error: <repl>:1: unterminated string\n") {
+		t.Errorf("no lexical error in %q", out)
+	}
+	if !strings.HasSuffix(out, "3.000000\n> ") {
+		t.Errorf("the line after the error did not run: %q", out)
+	}
+}
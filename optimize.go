@@ -0,0 +1,76 @@
+package main
+
+// instruction is a decoded opcode together with its operand, if any, and
+// the source position it was compiled from.
+type instruction struct {
+	op      Op
+	operand byte
+	line    int
+	column  int
+}
+
+// optimize runs a peephole pass over chunk, collapsing:
+//
+//	OpConstant n; OpNegate  ->  OpConstant -n
+//	OpTrue; OpNot           ->  OpFalse
+//	OpFalse; OpNot          ->  OpTrue
+//
+// Rewrites are applied as instructions are re-emitted, so chains such as
+// !!true or --5 fold completely. Each surviving instruction keeps its
+// original source position. The compiler never shares constant pool
+// entries between instructions, so negation rewrites the entry in place.
+// The pass also relies on there being no jumps; once control flow is
+// added it must avoid rewriting across jump targets.
+func optimize(chunk *Chunk) {
+	var out []instruction
+
+	for offset := 0; offset < len(chunk.code); {
+		in := instruction{op: Op(chunk.code[offset])}
+		in.line, in.column = chunk.positionAt(offset)
+		offset++
+
+		if in.op == OpConstant {
+			in.operand = chunk.code[offset]
+			offset++
+		}
+
+		out = append(out, in)
+
+		for n := len(out); n >= 2; n = len(out) {
+			folded, ok := fold(chunk, out[n-2], out[n-1])
+			if !ok {
+				break
+			}
+			out = append(out[:n-2], folded)
+		}
+	}
+
+	chunk.code, chunk.lines, chunk.columns = nil, nil, nil
+	for _, in := range out {
+		chunk.addOp(in.op, in.line, in.column)
+		if in.op == OpConstant {
+			chunk.addByte(in.operand, in.line, in.column)
+		}
+	}
+}
+
+// fold returns the single instruction equivalent to a followed by b, if
+// the pair matches one of the peephole patterns.
+func fold(chunk *Chunk, a, b instruction) (instruction, bool) {
+	switch {
+	case a.op == OpConstant && b.op == OpNegate:
+		val := chunk.vals[a.operand]
+		if val.typ != ValueNumber {
+			return instruction{}, false
+		}
+		chunk.vals[a.operand] = numberValue(-val.asNumber())
+		return a, true
+	case a.op == OpTrue && b.op == OpNot:
+		a.op = OpFalse
+		return a, true
+	case a.op == OpFalse && b.op == OpNot:
+		a.op = OpTrue
+		return a, true
+	}
+	return instruction{}, false
+}
@@ -0,0 +1,61 @@
+package main
+
+import "testing"
+
+func TestOptimize(t *testing.T) {
+	tests := []struct {
+		source string
+		code   string
+		want   Value
+	}{
+		{"-5", "-5", numberValue(-5)},
+		{"--5", "5", numberValue(5)},
+		{"-(5)", "-5", numberValue(-5)},
+		{"!true", "OpFalse", boolValue(false)},
+		{"!false", "OpTrue", boolValue(true)},
+		{"!!true", "OpTrue", boolValue(true)},
+		{"!!!false", "OpTrue", boolValue(true)},
+		{"-(1 + 2)", "1 2 OpAdd OpNegate", numberValue(-3)},
+		{"!nil", "OpNil OpNot", boolValue(true)},
+	}
+
+	for _, test := range tests {
+		chunk, err := newCompiler().compileExpression(test.source)
+		if err != nil {
+			t.Fatalf("%s: %s", test.source, err)
+		}
+		if got := disassemble(chunk); got != test.code {
+			t.Errorf("%s: got %s, want %s", test.source, got, test.code)
+		}
+		if len(chunk.lines) != len(chunk.code) || len(chunk.columns) != len(chunk.code) {
+			t.Errorf("%s: %d positions for %d bytes", test.source, len(chunk.lines), len(chunk.code))
+		}
+
+		if v, err := eval(t, test.source); err != nil || v != test.want {
+			t.Errorf("%s: got %v, %v, want %v", test.source, v, err, test.want)
+		}
+	}
+}
+
+func TestOptimizeKeepsPositions(t *testing.T) {
+	chunk := &Chunk{}
+	chunk.addOp(OpConstant, 1, 2)
+	chunk.addByte(byte(chunk.addVal(numberValue(5))), 1, 2)
+	chunk.addOp(OpNegate, 1, 1)
+	chunk.addOp(OpTrue, 2, 5)
+	chunk.addOp(OpNot, 2, 4)
+	chunk.addOp(OpReturn, 3, 1)
+
+	optimize(chunk)
+
+	if got := disassemble(chunk); got != "-5 OpFalse OpReturn" {
+		t.Fatalf("got %s", got)
+	}
+
+	positions := [][2]int{{1, 2}, {1, 2}, {2, 5}, {3, 1}}
+	for offset, want := range positions {
+		if line, column := chunk.positionAt(offset); line != want[0] || column != want[1] {
+			t.Errorf("offset %d: got %d:%d, want %d:%d", offset, line, column, want[0], want[1])
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"fmt"
+	"strings"
 	"unicode"
 	"unicode/utf8"
 )
@@ -19,6 +21,7 @@ const (
 	TokenPlus
 	TokenMinus
 	TokenStar
+	TokenStarStar
 	TokenSlash
 	TokenEqual
 	TokenEqualEqual
@@ -26,6 +29,7 @@ const (
 	TokenBangEqual
 	TokenLess
 	TokenLessEqual
+	TokenLessEqualGreater
 	TokenGreater
 	TokenGreaterEqual
 	TokenSemicolon
@@ -47,10 +51,89 @@ const (
 	TokenTrue
 	TokenVar
 	TokenWhile
+	TokenLine
 )
 
+var tokenDescriptions = map[TokenType]string{
+	TokenError:            "invalid token",
+	TokenEOF:              "end of input",
+	TokenLeftParen:        "'('",
+	TokenRightParen:       "')'",
+	TokenLeftBrace:        "'{'",
+	TokenRightBrace:       "'}'",
+	TokenComma:            "','",
+	TokenDot:              "'.'",
+	TokenPlus:             "'+'",
+	TokenMinus:            "'-'",
+	TokenStar:             "'*'",
+	TokenStarStar:         "'**'",
+	TokenSlash:            "'/'",
+	TokenEqual:            "'='",
+	TokenEqualEqual:       "'=='",
+	TokenBang:             "'!'",
+	TokenBangEqual:        "'!='",
+	TokenLess:             "'<'",
+	TokenLessEqual:        "'<='",
+	TokenLessEqualGreater: "'<=>'",
+	TokenGreater:          "'>'",
+	TokenGreaterEqual:     "'>='",
+	TokenSemicolon:        "';'",
+	TokenString:           "string",
+	TokenNumber:           "number",
+	TokenIdentifier:       "identifier",
+	TokenAnd:              "'and'",
+	TokenClass:            "'class'",
+	TokenElse:             "'else'",
+	TokenFalse:            "'false'",
+	TokenFor:              "'for'",
+	TokenFun:              "'fun'",
+	TokenIf:               "'if'",
+	TokenNil:              "'nil'",
+	TokenOr:               "'or'",
+	TokenPrint:            "'print'",
+	TokenReturn:           "'return'",
+	TokenSuper:            "'super'",
+	TokenTrue:             "'true'",
+	TokenVar:              "'var'",
+	TokenWhile:            "'while'",
+	TokenLine:             "'__line__'",
+}
+
+// describe returns a user-facing name for the token type, for use in
+// error messages.
+func (typ TokenType) describe() string {
+	if desc, ok := tokenDescriptions[typ]; ok {
+		return desc
+	}
+	return fmt.Sprint(typ)
+}
+
+// describe is like TokenType.describe but also quotes the lexeme of
+// identifiers, strings and numbers, shortened by truncateLexeme.
+func (t Token) describe() string {
+	switch t.typ {
+	case TokenIdentifier, TokenString, TokenNumber:
+		return fmt.Sprintf("%s '%s'", t.typ.describe(), truncateLexeme(t.data))
+	}
+	return t.typ.describe()
+}
+
+// maxLexemeInError is how many runes of a lexeme error messages show.
+const maxLexemeInError = 40
+
+// truncateLexeme shortens lexemes longer than maxLexemeInError runes,
+// marking the cut with an ellipsis.
+func truncateLexeme(lexeme string) string {
+	if utf8.RuneCountInString(lexeme) <= maxLexemeInError {
+		return lexeme
+	}
+	return string([]rune(lexeme)[:maxLexemeInError]) + "..."
+}
+
 type Scanner interface {
 	nextToken() Token
+	SaveState() ScannerState
+	RestoreState(state ScannerState)
 }
 
 type scanner struct {
@@ -58,16 +141,105 @@ type scanner struct {
 	start   int
 	current int
 	line    int
+
+	// lineStart is the byte offset at which the current line begins.
+	lineStart int
+
+	// maxTokenLen limits the length in bytes of identifier and number
+	// lexemes and of string contents. Zero means unlimited.
+	maxTokenLen int
+
+	// tabWidth is the distance between tab stops when computing columns.
+	tabWidth int
+}
+
+// ScannerState is an opaque snapshot of a scanner's position, used to
+// resume lexing from a known point.
+type ScannerState struct {
+	start     int
+	current   int
+	line      int
+	lineStart int
+}
+
+type scannerOption func(*scanner)
+
+// withMaxTokenLength makes the scanner return a TokenError for any
+// identifier or number longer than n bytes, or any string with more than
+// n bytes between its quotes.
+func withMaxTokenLength(n int) scannerOption {
+	return func(s *scanner) {
+		s.maxTokenLen = n
+	}
 }
 
 type Token struct {
-	typ  TokenType
-	line int
-	data string
+	typ    TokenType
+	line   int
+	column int
+	data   string
+}
+
+// byteOrderMark is the UTF-8 encoding of U+FEFF, which some editors write
+// at the start of a file.
+const byteOrderMark = "\ufeff"
+
+// withTabWidth makes a tab advance the column to the next multiple of n,
+// rather than counting as a single column.
+func withTabWidth(n int) scannerOption {
+	return func(s *scanner) {
+		s.tabWidth = n
+	}
+}
+
+func newScanner(source string, opts ...scannerOption) Scanner {
+	source = strings.TrimPrefix(source, byteOrderMark)
+	s := &scanner{source: source, tabWidth: 1}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	// a #! line at the very start lets scripts be executable; treat it
+	// as a comment
+	if strings.HasPrefix(source, "#!") {
+		s.skipUntilNewLine()
+	}
+
+	return s
 }
 
-func newScanner(source string) Scanner {
-	return &scanner{source: source}
+// dumpTokens prints every token in source, one per line. Error tokens are
+// listed with their message and scanning carries on past them, unless the
+// error consumed no input and would only be reported again.
+func dumpTokens(source string) {
+	s := newScanner(source)
+	for {
+		before := s.SaveState()
+		t := s.nextToken()
+		fmt.Printf("%4d:%-3d %-18v '%s'\n", t.line, t.column, t.typ, t.data)
+		if t.typ == TokenEOF {
+			return
+		}
+		if t.typ == TokenError && s.SaveState().current == before.current {
+			return
+		}
+	}
+}
+
+func (s *scanner) SaveState() ScannerState {
+	return ScannerState{
+		start:     s.start,
+		current:   s.current,
+		line:      s.line,
+		lineStart: s.lineStart,
+	}
+}
+
+func (s *scanner) RestoreState(state ScannerState) {
+	s.start = state.start
+	s.current = state.current
+	s.line = state.line
+	s.lineStart = state.lineStart
 }
 
 func (s *scanner) nextToken() Token {
@@ -101,12 +273,18 @@ func (s *scanner) nextToken() Token {
 	case ',':
 		return s.makeToken(TokenComma)
 	case '.':
+		if r, _ := s.currentRune(); isDigit(r) {
+			return s.fraction()
+		}
 		return s.makeToken(TokenDot)
 	case '+':
 		return s.makeToken(TokenPlus)
 	case '-':
 		return s.makeToken(TokenMinus)
 	case '*':
+		if s.match('*') {
+			return s.makeToken(TokenStarStar)
+		}
 		return s.makeToken(TokenStar)
 	case '/':
 		return s.makeToken(TokenSlash)
@@ -124,6 +302,9 @@ func (s *scanner) nextToken() Token {
 		}
 	case '<':
 		if s.match('=') {
+			if s.match('>') {
+				return s.makeToken(TokenLessEqualGreater)
+			}
 			return s.makeToken(TokenLessEqual)
 		} else {
 			return s.makeToken(TokenLess)
@@ -140,7 +321,7 @@ func (s *scanner) nextToken() Token {
 		return s.string()
 	}
 
-	return s.makeToken(TokenError)
+	return s.errorToken("unexpected character")
 }
 
 func (s *scanner) string() Token {
@@ -160,8 +341,7 @@ func (s *scanner) string() Token {
 	}
 
 	if s.isEOF() {
-		// unterminated string
-		return s.makeToken(TokenError)
+		return s.errorToken("unterminated string")
 	}
 
 	// closing quote
@@ -176,24 +356,70 @@ func isDigit(r rune) bool {
 }
 
 func (s *scanner) number() Token {
-	for {
-		r, size := s.currentRune()
-		if isDigit(r) {
-			s.current += size
-			continue
-		} else if r == '.' {
+	if s.source[s.start] == '0' && s.current == s.start+1 {
+		switch r, size := s.currentRune(); r {
+		case 'x', 'X', 'o', 'O', 'b', 'B':
 			s.current += size
-			r, size := s.currentRune()
-			for isDigit(r) {
-				s.current += size
-				r, size = s.currentRune()
-			}
+			return s.prefixedNumber()
 		}
-		break
 	}
+
+	s.skipDigits()
+	if r, size := s.currentRune(); r == '.' {
+		s.current += size
+		return s.fraction()
+	}
+	return s.exponent()
+}
+
+// prefixedNumber scans the digits of a 0x, 0o or 0b literal. Any letters
+// and digits are taken so that a literal such as 0b102 stays one token
+// and is rejected as a whole by the compiler.
+func (s *scanner) prefixedNumber() Token {
+	r, size := s.currentRune()
+	for isAlpha(r) || isDigit(r) {
+		s.current += size
+		r, size = s.currentRune()
+	}
+	return s.makeToken(TokenNumber)
+}
+
+// fraction scans the digits following a decimal point, so that both 1.5
+// and .5 end up here, and then any exponent.
+func (s *scanner) fraction() Token {
+	s.skipDigits()
+	return s.exponent()
+}
+
+// exponent scans an optional e/E exponent. Once the e is seen, a signed
+// run of digits must follow: 1.e5 is a number but 1.e is an error.
+func (s *scanner) exponent() Token {
+	r, size := s.currentRune()
+	if r != 'e' && r != 'E' {
+		return s.makeToken(TokenNumber)
+	}
+	s.current += size
+
+	if r, size := s.currentRune(); r == '+' || r == '-' {
+		s.current += size
+	}
+
+	if r, _ := s.currentRune(); !isDigit(r) {
+		return s.errorToken("malformed number exponent")
+	}
+	s.skipDigits()
+
 	return s.makeToken(TokenNumber)
 }
 
+func (s *scanner) skipDigits() {
+	r, size := s.currentRune()
+	for isDigit(r) {
+		s.current += size
+		r, size = s.currentRune()
+	}
+}
+
 func isAlpha(r rune) bool {
 	return unicode.IsLetter(r) || r == '_'
 }
@@ -238,17 +464,56 @@ func (s *scanner) identifier() Token {
 		token.typ = TokenVar
 	case "while":
 		token.typ = TokenWhile
+	case "__line__":
+		token.typ = TokenLine
 	}
 
 	return token
 }
 
 func (s *scanner) makeToken(typ TokenType) Token {
+	switch typ {
+	case TokenIdentifier, TokenString, TokenNumber:
+		n := s.current - s.start
+		if typ == TokenString {
+			// the quotes don't count towards a string's length
+			n -= 2
+		}
+		if s.maxTokenLen > 0 && n > s.maxTokenLen {
+			lexeme := truncateLexeme(s.source[s.start:s.current])
+			return s.errorToken(fmt.Sprintf("token too long: '%s'", lexeme))
+		}
+	}
+
+	return Token{
+		typ:    typ,
+		line:   s.line + 1,
+		column: s.column(),
+		data:   s.source[s.start:s.current],
+	}
+}
+
+func (s *scanner) errorToken(msg string) Token {
 	return Token{
-		typ:  typ,
-		line: s.line + 1,
-		data: s.source[s.start:s.current],
+		typ:    TokenError,
+		line:   s.line + 1,
+		column: s.column(),
+		data:   msg,
+	}
+}
+
+// column returns the 1-based column of the current token's start,
+// counting runes and expanding tabs to tabWidth.
+func (s *scanner) column() int {
+	col := 0
+	for _, r := range s.source[s.lineStart:s.start] {
+		if r == '\t' && s.tabWidth > 1 {
+			col += s.tabWidth - col%s.tabWidth
+		} else {
+			col++
+		}
 	}
+	return col + 1
 }
 
 func (s *scanner) isEOF() bool {
@@ -280,10 +545,12 @@ func (s *scanner) skipWhitespace() {
 		case '\n':
 			s.line++
 			s.current += size
+			s.lineStart = s.current
 			continue
 		case '/':
 			if n, _ := s.runeAt(s.current + size); n == '/' {
 				s.skipUntilNewLine()
+				continue
 			}
 		}
 		break
@@ -303,7 +570,7 @@ func (s *scanner) currentRune() (rune, int) {
 }
 
 func (s *scanner) runeAt(index int) (rune, int) {
-	if index >= utf8.RuneCountInString(s.source) {
+	if index >= len(s.source) {
 		return -1, 0
 	}
 	return utf8.DecodeRuneInString(s.source[index:])
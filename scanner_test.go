@@ -0,0 +1,274 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// scanAll returns every token in source up to and including TokenEOF.
+func scanAll(source string, opts ...scannerOption) []Token {
+	return scanRest(newScanner(source, opts...))
+}
+
+// scanRest returns the remaining tokens from s up to and including
+// TokenEOF.
+func scanRest(s Scanner) []Token {
+	var tokens []Token
+	for {
+		t := s.nextToken()
+		tokens = append(tokens, t)
+		if t.typ == TokenEOF {
+			return tokens
+		}
+	}
+}
+
+func TestMaxTokenLength(t *testing.T) {
+	tests := []struct {
+		source string
+		typ    TokenType
+		data   string
+	}{
+		{"abcde", TokenIdentifier, "abcde"},
+		{"abcdef", TokenError, "token too long: 'abcdef'"},
+		{"12345", TokenNumber, "12345"},
+		{"123456", TokenError, "token too long: '123456'"},
+		{`"abcde"`, TokenString, `"abcde"`},
+		{`"abcdef"`, TokenError, `token too long: '"abcdef"'`},
+		{`""`, TokenString, `""`},
+	}
+
+	for _, test := range tests {
+		tok := scanAll(test.source, withMaxTokenLength(5))[0]
+		if tok.typ != test.typ || tok.data != test.data {
+			t.Errorf("%s: got %v '%s', want %v '%s'", test.source, tok.typ, tok.data, test.typ, test.data)
+		}
+	}
+}
+
+func TestMaxTokenLengthDefaultsToUnlimited(t *testing.T) {
+	long := strings.Repeat("a", 10000)
+	if tok := scanAll(long)[0]; tok.typ != TokenIdentifier {
+		t.Errorf("got %v, want TokenIdentifier", tok.typ)
+	}
+}
+
+func TestMaxTokenLengthThroughCompiler(t *testing.T) {
+	c := newCompiler(withScannerOptions(withMaxTokenLength(4)))
+
+	if _, err := c.compile("1 + 1234"); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	_, err := c.compile("1 + 12345")
+	if err == nil || err.Error() != "1: token too long: '12345'" {
+		t.Errorf("got error %v, want token too long", err)
+	}
+}
+
+func TestSaveRestoreState(t *testing.T) {
+	head, tail := "1 + 2\n* ", "(3 - 4)\n== 5"
+
+	s := newScanner(head + tail)
+	for i := 0; i < 4; i++ {
+		s.nextToken()
+	}
+	state := s.SaveState()
+
+	first := scanRest(s)
+	s.RestoreState(state)
+	if second := scanRest(s); !reflect.DeepEqual(first, second) {
+		t.Errorf("resumed scan differs:\n%v\n%v", first, second)
+	}
+
+	fresh := scanAll(tail)
+	if len(fresh) != len(first) {
+		t.Fatalf("got %d tokens, want %d", len(first), len(fresh))
+	}
+	for i := range fresh {
+		if first[i].typ != fresh[i].typ || first[i].data != fresh[i].data {
+			t.Errorf("token %d: got %v '%s', want %v '%s'", i, first[i].typ, first[i].data, fresh[i].typ, fresh[i].data)
+		}
+	}
+}
+
+func TestNumberForms(t *testing.T) {
+	tests := []struct {
+		source string
+		typ    TokenType
+		data   string
+	}{
+		{".5", TokenNumber, ".5"},
+		{"1.5", TokenNumber, "1.5"},
+		{"1.e5", TokenNumber, "1.e5"},
+		{"1e-5", TokenNumber, "1e-5"},
+		{"1.e", TokenError, "malformed number exponent"},
+		{"1e+", TokenError, "malformed number exponent"},
+		{".", TokenDot, "."},
+		{". 5", TokenDot, "."},
+	}
+
+	for _, test := range tests {
+		tok := scanAll(test.source)[0]
+		if tok.typ != test.typ || tok.data != test.data {
+			t.Errorf("%s: got %v '%s', want %v '%s'", test.source, tok.typ, tok.data, test.typ, test.data)
+		}
+	}
+}
+
+func TestLeadingDotNumberValue(t *testing.T) {
+	if got := compileListing(t, ".5 + 1.e1"); got != "0.5 10 OpAdd OpReturn" {
+		t.Errorf("got %s", got)
+	}
+}
+
+func TestByteOrderMark(t *testing.T) {
+	source := "1 +\n  (2 * 3)"
+
+	want := scanAll(source)
+	if got := scanAll(byteOrderMark + source); !reflect.DeepEqual(got, want) {
+		t.Errorf("with BOM got %v, want %v", got, want)
+	}
+}
+
+func TestTabWidth(t *testing.T) {
+	tests := []struct {
+		source string
+		width  int
+		column int
+	}{
+		{"\t\t1", 1, 3},
+		{"\t\t1", 4, 9},
+		{"\t\t1", 8, 17},
+		{"  \t1", 4, 5},
+		{"     \t1", 4, 9},
+	}
+
+	for _, test := range tests {
+		tok := scanAll(test.source, withTabWidth(test.width))[0]
+		if tok.column != test.column {
+			t.Errorf("%q with width %d: got column %d, want %d", test.source, test.width, tok.column, test.column)
+		}
+	}
+}
+
+func TestTabWidthThroughCompiler(t *testing.T) {
+	opt := withScannerOptions(withTabWidth(8))
+
+	chunk, err := newCompiler(opt).compile("1 +\n\t2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, column := chunk.positionAt(2); column != 9 {
+		t.Errorf("chunk column: got %d, want 9", column)
+	}
+
+	_, err = newCompiler(opt).compile("1 +\n\t)")
+	var cerr *CompileError
+	if !errors.As(err, &cerr) || cerr.column != 9 {
+		t.Errorf("compile error: got %v, want column 9", err)
+	}
+
+	diags := Check("1 +\n\t)", opt)
+	if len(diags) != 1 || diags[0].column != 9 {
+		t.Errorf("Check: got %v, want one diagnostic at column 9", diags)
+	}
+}
+
+func TestShebang(t *testing.T) {
+	chunk, err := newCompiler().compile("#!/usr/bin/env glox\n1 + 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line, _ := chunk.positionAt(0); line != 2 {
+		t.Errorf("first instruction on line %d, want 2", line)
+	}
+	if got := disassemble(chunk); got != "1 2 OpAdd OpReturn" {
+		t.Errorf("got %s", got)
+	}
+
+	for _, source := range []string{"1 +\n#!2", " #!/usr/bin/env glox\n1", "1 # 2"} {
+		if got := compileError(source); !strings.HasSuffix(got, "unexpected character") {
+			t.Errorf("%q: got %q, want unexpected character", source, got)
+		}
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	types := []struct {
+		typ  TokenType
+		want string
+	}{
+		{TokenRightParen, "')'"},
+		{TokenLessEqualGreater, "'<=>'"},
+		{TokenNumber, "number"},
+		{TokenIdentifier, "identifier"},
+		{TokenEOF, "end of input"},
+		{TokenWhile, "'while'"},
+	}
+	for _, test := range types {
+		if got := test.typ.describe(); got != test.want {
+			t.Errorf("%v: got %s, want %s", test.typ, got, test.want)
+		}
+	}
+
+	tokens := []struct {
+		token Token
+		want  string
+	}{
+		{Token{typ: TokenNumber, data: "42"}, "number '42'"},
+		{Token{typ: TokenIdentifier, data: "x"}, "identifier 'x'"},
+		{Token{typ: TokenPlus, data: "+"}, "'+'"},
+	}
+	for _, test := range tokens {
+		if got := test.token.describe(); got != test.want {
+			t.Errorf("%v: got %s, want %s", test.token, got, test.want)
+		}
+	}
+}
+
+func TestErrorsUseDescriptions(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"(1 2", "1: expected ')', got number '2'"},
+		{"(1 foo", "1: unexpected identifier 'foo'"},
+		{"(1 (", "1: expected ')', got '('"},
+		{"1 + ;", "1: unexpected ';'"},
+		{"1 + )", "1: unexpected ')'"},
+	}
+
+	for _, test := range tests {
+		if got := compileError(test.source); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.source, got, test.want)
+		}
+	}
+}
+
+func TestLongLexemesTruncated(t *testing.T) {
+	long := strings.Repeat("abcdefghij", 6)
+	cut := long[:maxLexemeInError] + "..."
+
+	if got := truncateLexeme(long[:maxLexemeInError]); got != long[:maxLexemeInError] {
+		t.Errorf("lexeme at the limit was truncated: %s", got)
+	}
+
+	tests := []struct {
+		source string
+		opts   []compilerOption
+		want   string
+	}{
+		{"1 " + long, nil, "1: unexpected identifier '" + cut + "'"},
+		{"(1 " + long, nil, "1: unexpected identifier '" + cut + "'"},
+		{"1 +\n" + long, []compilerOption{withScannerOptions(withMaxTokenLength(50))}, "2: token too long: '" + cut + "'"},
+	}
+
+	for _, test := range tests {
+		if got := compileError(test.source, test.opts...); got != test.want {
+			t.Errorf("got %q, want %q", got, test.want)
+		}
+	}
+}
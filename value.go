@@ -1,6 +1,9 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"math"
+)
 
 type ValueType byte
 
@@ -10,6 +13,12 @@ const (
 	ValueNumber
 )
 
+var valueTypeNames = map[ValueType]string{
+	ValueNil:    "nil",
+	ValueBool:   "bool",
+	ValueNumber: "number",
+}
+
 type Value struct {
 	typ  ValueType
 	data interface{}
@@ -38,12 +47,55 @@ func (v Value) String() string {
 			return "false"
 		}
 	case ValueNumber:
-		return fmt.Sprintf("%f", v.data)
+		f := v.asNumber()
+		switch {
+		case math.IsInf(f, 1):
+			return "Infinity"
+		case math.IsInf(f, -1):
+			return "-Infinity"
+		case math.IsNaN(f):
+			return "NaN"
+		}
+		return fmt.Sprintf("%f", f)
 	default:
 		return "<unknown type>"
 	}
 }
 
+// ToGo converts v to its natural Go representation: nil, bool or float64.
+func ToGo(v Value) (interface{}, error) {
+	switch v.typ {
+	case ValueNil:
+		return nil, nil
+	case ValueBool:
+		return v.asBool(), nil
+	case ValueNumber:
+		return v.asNumber(), nil
+	default:
+		return nil, fmt.Errorf("cannot convert %s to a Go value", v)
+	}
+}
+
+// FromGo converts a Go nil, bool or number into a Value.
+func FromGo(x interface{}) (Value, error) {
+	switch x := x.(type) {
+	case nil:
+		return nilValue(), nil
+	case bool:
+		return boolValue(x), nil
+	case float64:
+		return numberValue(x), nil
+	case float32:
+		return numberValue(float64(x)), nil
+	case int:
+		return numberValue(float64(x)), nil
+	case int64:
+		return numberValue(float64(x)), nil
+	default:
+		return Value{}, fmt.Errorf("cannot convert Go %T to a value", x)
+	}
+}
+
 func (v Value) asBool() bool {
 	switch v.typ {
 	case ValueBool:
@@ -94,6 +146,17 @@ func divideValues(v, w Value) (Value, error) {
 	return Value{}, fmt.Errorf("type mismatch")
 }
 
+// powerValues raises v to the power w with math.Pow, so negative and
+// fractional exponents work as expected and 0 ** 0 is 1. A negative base
+// with a fractional exponent has no real result and yields NaN rather
+// than an error.
+func powerValues(v, w Value) (Value, error) {
+	if v.typ == ValueNumber && w.typ == ValueNumber {
+		return numberValue(math.Pow(v.asNumber(), w.asNumber())), nil
+	}
+	return Value{}, fmt.Errorf("type mismatch")
+}
+
 func valuesEqual(v, w Value) (Value, error) {
 	res := false
 
@@ -118,6 +181,27 @@ func valueGreater(v, w Value) (Value, error) {
 	return Value{}, fmt.Errorf("type mismatch")
 }
 
+// compareValues implements the three-way comparison operator, yielding
+// -1, 0 or 1 as v is less than, equal to or greater than w. NaN is
+// unordered with respect to every number, itself included, so comparing
+// against it yields NaN rather than an error.
+func compareValues(v, w Value) (Value, error) {
+	if v.typ == ValueNumber && w.typ == ValueNumber {
+		a, b := v.asNumber(), w.asNumber()
+		switch {
+		case a < b:
+			return numberValue(-1), nil
+		case a > b:
+			return numberValue(1), nil
+		case a == b:
+			return numberValue(0), nil
+		default:
+			return numberValue(math.NaN()), nil
+		}
+	}
+	return Value{}, fmt.Errorf("type mismatch")
+}
+
 func valueLess(v, w Value) (Value, error) {
 	if v.typ == ValueNumber && w.typ == ValueNumber {
 		return boolValue(v.asNumber() < w.asNumber()), nil
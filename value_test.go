@@ -0,0 +1,141 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestGoRoundTrip(t *testing.T) {
+	for _, x := range []interface{}{nil, true, false, 0.0, -1.5, 1e300} {
+		v, err := FromGo(x)
+		if err != nil {
+			t.Errorf("FromGo(%v): %s", x, err)
+			continue
+		}
+		back, err := ToGo(v)
+		if err != nil {
+			t.Errorf("ToGo(%v): %s", v, err)
+			continue
+		}
+		if back != x {
+			t.Errorf("%v round tripped to %v", x, back)
+		}
+	}
+}
+
+func TestFromGoIntegers(t *testing.T) {
+	for _, x := range []interface{}{int(3), int64(3), float32(3)} {
+		v, err := FromGo(x)
+		if err != nil || v != numberValue(3) {
+			t.Errorf("FromGo(%T): got %v, %v, want 3", x, v, err)
+		}
+	}
+}
+
+func TestGoConversionErrors(t *testing.T) {
+	for _, x := range []interface{}{"text", []interface{}{1.0}, map[string]interface{}{}} {
+		if _, err := FromGo(x); err == nil {
+			t.Errorf("FromGo(%T): expected an error", x)
+		}
+	}
+
+	if _, err := ToGo(Value{typ: ValueType(255)}); err == nil {
+		t.Error("ToGo of an unknown value type: expected an error")
+	}
+}
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		source string
+		want   float64
+	}{
+		{"1 <=> 2", -1},
+		{"2 <=> 2", 0},
+		{"3 <=> 2", 1},
+		{"-1 <=> -2", 1},
+		{"1 + 1 <=> 2", 0},
+	}
+
+	for _, test := range tests {
+		v, err := eval(t, test.source)
+		if err != nil || v != numberValue(test.want) {
+			t.Errorf("%s: got %v, %v, want %v", test.source, v, err, test.want)
+		}
+	}
+}
+
+func TestCompareNaN(t *testing.T) {
+	for _, source := range []string{"0/0 <=> 1", "1 <=> 0/0", "0/0 <=> 0/0"} {
+		v, err := eval(t, source)
+		if err != nil || v.typ != ValueNumber || !math.IsNaN(v.asNumber()) {
+			t.Errorf("%s: got %v, %v, want NaN", source, v, err)
+		}
+	}
+}
+
+func TestCompareTypeMismatch(t *testing.T) {
+	for _, source := range []string{"1 <=> true", "nil <=> 1", "false <=> true"} {
+		if _, err := eval(t, source); err == nil || !strings.HasSuffix(err.Error(), "type mismatch") {
+			t.Errorf("%s: got error %v, want type mismatch", source, err)
+		}
+	}
+}
+
+func TestSpecialNumbers(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"1/0", "Infinity"},
+		{"-1/0", "-Infinity"},
+		{"0/0", "NaN"},
+		{"-(0/0)", "NaN"},
+		{"0/0 != 0/0", "true"},
+		{"0/0 == 0/0", "false"},
+		{"1/0 == 2/0", "true"},
+	}
+
+	for _, test := range tests {
+		v, err := eval(t, test.source)
+		if err != nil {
+			t.Errorf("%s: %s", test.source, err)
+			continue
+		}
+		if got := v.String(); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.source, got, test.want)
+		}
+	}
+}
+
+func TestPower(t *testing.T) {
+	tests := []struct {
+		source string
+		want   float64
+	}{
+		{"2 ** 10", 1024},
+		{"2 ** -1", 0.5},
+		{"4 ** 0.5", 2},
+		{"0 ** 0", 1},
+		{"2 ** 3 ** 2", 512},
+		{"-2 ** 2", -4},
+		{"(-2) ** 2", 4},
+		{"(-8) ** (1/3)", math.NaN()},
+	}
+
+	for _, test := range tests {
+		v, err := eval(t, test.source)
+		if err != nil {
+			t.Errorf("%s: %s", test.source, err)
+			continue
+		}
+		got := v.asNumber()
+		if got != test.want && !(math.IsNaN(got) && math.IsNaN(test.want)) {
+			t.Errorf("%s: got %v, want %v", test.source, got, test.want)
+		}
+	}
+
+	if _, err := eval(t, "2 ** true"); err == nil || !strings.HasSuffix(err.Error(), "type mismatch") {
+		t.Errorf("got error %v, want type mismatch", err)
+	}
+}
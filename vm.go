@@ -1,6 +1,10 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
 
 type Op byte
 
@@ -15,9 +19,11 @@ const (
 	OpSubtract
 	OpMultiply
 	OpDivide
+	OpPower
 	OpEqual
 	OpGreater
 	OpLess
+	OpCompare
 	OpReturn
 )
 
@@ -33,6 +39,10 @@ func (s *Stack) push(val Value) {
 	s.vals = append(s.vals, val)
 }
 
+func (s *Stack) isEmpty() bool {
+	return len(s.vals) == 0
+}
+
 func (s *Stack) pop() Value {
 	n := len(s.vals) - 1
 	val := s.vals[n]
@@ -43,14 +53,29 @@ func (s *Stack) pop() Value {
 type Chunk struct {
 	code []byte
 	vals []Value
+
+	// name identifies the source the chunk was compiled from.
+	name string
+
+	// lines and columns record the source position of each byte in code.
+	lines   []int
+	columns []int
 }
 
-func (c *Chunk) addByte(b byte) {
+func (c *Chunk) addByte(b byte, line, column int) {
 	c.code = append(c.code, b)
+	c.lines = append(c.lines, line)
+	c.columns = append(c.columns, column)
 }
 
-func (c *Chunk) addOp(op Op) {
-	c.addByte(byte(op))
+func (c *Chunk) addOp(op Op, line, column int) {
+	c.addByte(byte(op), line, column)
+}
+
+// positionAt returns the source line and column that the byte at offset
+// was compiled from.
+func (c *Chunk) positionAt(offset int) (line, column int) {
+	return c.lines[offset], c.columns[offset]
 }
 
 func (c *Chunk) addVal(val Value) int {
@@ -65,6 +90,26 @@ func dumpChunk(c *Chunk, title string) {
 	}
 }
 
+// dumpChunkWithSource is dumpChunk annotated with source: whenever the
+// instructions move to a different line, that line's trimmed text is
+// printed ahead of them.
+func dumpChunkWithSource(c *Chunk, title, source string) {
+	lines := strings.Split(source, "\n")
+	prev := 0
+
+	fmt.Printf("== %s\n", title)
+	for i := 0; i < len(c.code); {
+		line, _ := c.positionAt(i)
+		if line != prev && line >= 1 && line <= len(lines) {
+			if text := strings.TrimSpace(lines[line-1]); text != "" {
+				fmt.Printf("     ; %d: %s\n", line, text)
+			}
+		}
+		prev = line
+		i += dumpOp(c, i)
+	}
+}
+
 func dumpOp(c *Chunk, offset int) int {
 	op := Op(c.code[offset])
 
@@ -73,7 +118,15 @@ func dumpOp(c *Chunk, offset int) int {
 
 	switch op {
 	case OpConstant:
+		if offset+1 >= len(c.code) {
+			fmt.Print(" <missing operand>")
+			return 2
+		}
 		val := c.code[offset+1]
+		if int(val) >= len(c.vals) {
+			fmt.Printf(" %3d <out of range>", val)
+			return 2
+		}
 		fmt.Printf(" %3d [%s]", val, c.vals[val])
 		return 2
 	}
@@ -83,87 +136,210 @@ func dumpOp(c *Chunk, offset int) int {
 
 type VM interface {
 	run(chunk *Chunk) error
+	ExecutedLines() []int
 }
 
-type vm struct{}
+type vm struct {
+	chunk    *Chunk
+	ip       int
+	stack    *Stack
+	executed map[int]bool
+
+	// offset is where the instruction currently executing starts.
+	offset int
+
+	// trace prints each instruction as it is executed.
+	trace bool
+}
+
+// RuntimeError reports a failure while executing the instruction at
+// offset, along with the source it was compiled from.
+type RuntimeError struct {
+	offset int
+	op     Op
+	name   string
+	line   int
+	err    error
+}
+
+func (e *RuntimeError) Error() string {
+	return fmt.Sprintf("%s: %s", sourcePosition(e.name, e.line), e.err)
+}
+
+func (e *RuntimeError) Unwrap() error {
+	return e.err
+}
 
 func newVM() VM {
-	return vm{}
+	return &vm{trace: true}
 }
 
-func (vm vm) run(chunk *Chunk) error {
-	stack := newStack()
+func (vm *vm) run(chunk *Chunk) (err error) {
+	vm.reset(chunk)
+	defer vm.recoverPanic(&err)
 
-	literal := func(v Value) error {
-		stack.push(v)
-		return nil
+	for {
+		done, err := vm.step()
+		if err != nil {
+			return vm.runtimeError(err)
+		}
+		if done {
+			return nil
+		}
 	}
+}
 
-	unary := func(fn func(Value) (Value, error)) error {
-		v := stack.pop()
-		res, err := fn(v)
-		if err == nil {
-			stack.push(res)
-		}
-		return err
+// runtimeError wraps err with the position of the current instruction.
+func (vm *vm) runtimeError(err error) *RuntimeError {
+	rerr := &RuntimeError{offset: vm.offset, name: vm.chunk.name, err: err}
+	if vm.offset < len(vm.chunk.code) {
+		rerr.op = Op(vm.chunk.code[vm.offset])
 	}
+	if vm.offset < len(vm.chunk.lines) {
+		rerr.line, _ = vm.chunk.positionAt(vm.offset)
+	}
+	return rerr
+}
 
-	binary := func(fn func(Value, Value) (Value, error)) error {
-		b := stack.pop()
-		a := stack.pop()
-		res, err := fn(a, b)
-		if err == nil {
-			stack.push(res)
-		}
-		return err
-	}
-
-	for ip := 0; ip < len(chunk.code); ip++ {
-		dumpOp(chunk, ip)
-		op := Op(chunk.code[ip])
-
-		var err error
-
-		switch op {
-		case OpConstant:
-			ip++
-			err = literal(chunk.vals[chunk.code[ip]])
-		case OpNil:
-			err = literal(nilValue())
-		case OpFalse:
-			err = literal(boolValue(false))
-		case OpTrue:
-			err = literal(boolValue(true))
-		case OpNegate:
-			err = unary(negateValue)
-		case OpNot:
-			err = unary(notValue)
-		case OpAdd:
-			err = binary(addValues)
-		case OpSubtract:
-			err = binary(subtractValues)
-		case OpMultiply:
-			err = binary(multiplyValues)
-		case OpDivide:
-			err = binary(divideValues)
-		case OpEqual:
-			err = binary(valuesEqual)
-		case OpGreater:
-			err = binary(valueGreater)
-		case OpLess:
-			err = binary(valueLess)
-		case OpReturn:
-			fmt.Println(stack.pop())
-		default:
-			err = fmt.Errorf("unknown op: %q\n", op)
-		}
+// recoverPanic converts a panic raised while executing an instruction,
+// which can only come from an interpreter bug or corrupt bytecode, into a
+// RuntimeError so that it never takes down the host process.
+func (vm *vm) recoverPanic(err *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
 
-		if err != nil {
-			return err
-		}
+	rerr := vm.runtimeError(nil)
+	rerr.err = fmt.Errorf("internal error at %04d %v: %v", rerr.offset, rerr.op, r)
+	*err = rerr
+}
+
+// reset prepares the VM to execute chunk from its first instruction.
+func (vm *vm) reset(chunk *Chunk) {
+	vm.chunk = chunk
+	vm.ip = 0
+	vm.stack = newStack()
+	vm.executed = map[int]bool{}
+}
+
+// ExecutedLines returns the sorted source lines of every instruction
+// executed since the last run started.
+func (vm *vm) ExecutedLines() []int {
+	lines := make([]int, 0, len(vm.executed))
+	for line := range vm.executed {
+		lines = append(lines, line)
 	}
+	sort.Ints(lines)
+	return lines
+}
 
+// readConstant decodes the constant operand at ip, rejecting operands that
+// are missing or point past the constant table.
+func (vm *vm) readConstant() (Value, error) {
+	chunk := vm.chunk
+	if vm.ip >= len(chunk.code) {
+		return Value{}, fmt.Errorf("corrupt bytecode: missing constant operand")
+	}
+
+	index := int(chunk.code[vm.ip])
+	vm.ip++
+
+	if index >= len(chunk.vals) {
+		return Value{}, fmt.Errorf("corrupt bytecode: constant index out of range")
+	}
+	return chunk.vals[index], nil
+}
+
+func (vm *vm) literal(v Value) error {
+	vm.stack.push(v)
 	return nil
 }
 
+func (vm *vm) unary(fn func(Value) (Value, error)) error {
+	v := vm.stack.pop()
+	res, err := fn(v)
+	if err == nil {
+		vm.stack.push(res)
+	}
+	return err
+}
+
+func (vm *vm) binary(fn func(Value, Value) (Value, error)) error {
+	b := vm.stack.pop()
+	a := vm.stack.pop()
+	res, err := fn(a, b)
+	if err == nil {
+		vm.stack.push(res)
+	}
+	return err
+}
+
+// step executes the instruction at ip and advances past it. done is true
+// once the chunk has returned or run out of code.
+func (vm *vm) step() (done bool, err error) {
+	chunk := vm.chunk
+	if vm.ip >= len(chunk.code) {
+		return true, nil
+	}
+
+	vm.offset = vm.ip
+	if vm.ip < len(chunk.lines) {
+		line, _ := chunk.positionAt(vm.ip)
+		vm.executed[line] = true
+	}
+
+	if vm.trace {
+		dumpOp(chunk, vm.ip)
+	}
+	op := Op(chunk.code[vm.ip])
+	vm.ip++
+
+	switch op {
+	case OpConstant:
+		var val Value
+		if val, err = vm.readConstant(); err == nil {
+			err = vm.literal(val)
+		}
+	case OpNil:
+		err = vm.literal(nilValue())
+	case OpFalse:
+		err = vm.literal(boolValue(false))
+	case OpTrue:
+		err = vm.literal(boolValue(true))
+	case OpNegate:
+		err = vm.unary(negateValue)
+	case OpNot:
+		err = vm.unary(notValue)
+	case OpAdd:
+		err = vm.binary(addValues)
+	case OpSubtract:
+		err = vm.binary(subtractValues)
+	case OpMultiply:
+		err = vm.binary(multiplyValues)
+	case OpDivide:
+		err = vm.binary(divideValues)
+	case OpPower:
+		err = vm.binary(powerValues)
+	case OpEqual:
+		err = vm.binary(valuesEqual)
+	case OpGreater:
+		err = vm.binary(valueGreater)
+	case OpLess:
+		err = vm.binary(valueLess)
+	case OpCompare:
+		err = vm.binary(compareValues)
+	case OpReturn:
+		// an empty program leaves nothing to print
+		if !vm.stack.isEmpty() {
+			fmt.Println(vm.stack.pop())
+		}
+		return true, nil
+	default:
+		err = fmt.Errorf("unknown op: %q\n", op)
+	}
+
+	return false, err
+}
+
 //go:generate stringer -type=Op
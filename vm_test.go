@@ -0,0 +1,282 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// capture redirects *f, such as os.Stdout, to a pipe while fn runs and
+// returns everything written to it.
+func capture(t *testing.T, f **os.File, fn func()) string {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		out <- string(b)
+	}()
+
+	saved := *f
+	*f = w
+	defer func() {
+		*f = saved
+	}()
+
+	fn()
+
+	w.Close()
+	return <-out
+}
+
+// eval compiles source as an expression and runs it without tracing,
+// returning the value it leaves on the stack.
+func eval(t *testing.T, source string) (Value, error) {
+	t.Helper()
+	chunk, err := newCompiler().compileExpression(source)
+	if err != nil {
+		t.Fatalf("%s: %s", source, err)
+	}
+
+	vm := &vm{}
+	if err := vm.run(chunk); err != nil {
+		return Value{}, err
+	}
+	return vm.stack.pop(), nil
+}
+
+func TestEmptyPrograms(t *testing.T) {
+	for _, source := range []string{"", "// nothing to see\n// here", "  \n\t\r\n  "} {
+		chunk, err := newCompiler().compile(source)
+		if err != nil {
+			t.Errorf("%q: %s", source, err)
+			continue
+		}
+
+		var runErr error
+		out := capture(t, &os.Stdout, func() {
+			runErr = (&vm{}).run(chunk)
+		})
+		if runErr != nil {
+			t.Errorf("%q: %s", source, runErr)
+		}
+		if out != "" {
+			t.Errorf("%q: printed %q, want nothing", source, out)
+		}
+	}
+}
+
+func TestPositionAt(t *testing.T) {
+	chunk, err := newCompiler().compile("1 +\n  2 *\n    3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		offset       int
+		line, column int
+	}{
+		{0, 1, 1}, // OpConstant 1
+		{1, 1, 1}, // its operand
+		{2, 2, 3}, // OpConstant 2
+		{4, 3, 5}, // OpConstant 3
+		{6, 2, 5}, // OpMultiply
+		{7, 1, 3}, // OpAdd
+		{8, 3, 6}, // OpReturn
+	}
+
+	for _, test := range tests {
+		line, column := chunk.positionAt(test.offset)
+		if line != test.line || column != test.column {
+			t.Errorf("offset %d: got %d:%d, want %d:%d", test.offset, line, column, test.line, test.column)
+		}
+	}
+}
+
+func TestCorruptConstantOperand(t *testing.T) {
+	tests := []struct {
+		code []byte
+		want string
+	}{
+		{[]byte{byte(OpConstant), 5, byte(OpReturn)}, "corrupt bytecode: constant index out of range"},
+		{[]byte{byte(OpConstant)}, "corrupt bytecode: missing constant operand"},
+	}
+
+	for _, test := range tests {
+		chunk := &Chunk{}
+		chunk.addVal(numberValue(1))
+		for _, b := range test.code {
+			chunk.addByte(b, 1, 1)
+		}
+
+		err := (&vm{}).run(chunk)
+
+		var rerr *RuntimeError
+		if !errors.As(err, &rerr) {
+			t.Errorf("%v: got %v, want a RuntimeError", test.code, err)
+			continue
+		}
+		if !strings.HasSuffix(err.Error(), test.want) {
+			t.Errorf("%v: got %s, want %s", test.code, err, test.want)
+		}
+	}
+}
+
+func TestPanicBecomesRuntimeError(t *testing.T) {
+	// OpAdd with nothing on the stack underflows it
+	chunk := &Chunk{name: "bad"}
+	chunk.addOp(OpTrue, 3, 1)
+	chunk.addOp(OpAdd, 4, 2)
+
+	err := (&vm{}).run(chunk)
+
+	var rerr *RuntimeError
+	if !errors.As(err, &rerr) {
+		t.Fatalf("got %v, want a RuntimeError", err)
+	}
+	if rerr.offset != 1 || rerr.op != OpAdd || rerr.line != 4 {
+		t.Errorf("got offset %d, op %v, line %d, want 1, OpAdd, 4", rerr.offset, rerr.op, rerr.line)
+	}
+	if !strings.HasPrefix(err.Error(), "bad:4: internal error at 0001 OpAdd: ") {
+		t.Errorf("got %s", err)
+	}
+}
+
+func TestDumpChunkWithSource(t *testing.T) {
+	source := "1 +\n  2"
+	chunk, err := newCompiler().compile(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := capture(t, &os.Stdout, func() {
+		dumpChunkWithSource(chunk, "test", source)
+	})
+
+	want := "" +
+		"== test\n" +
+		"     ; 1: 1 +\n" +
+		"0000 OpConstant   0 [1.000000]\n" +
+		"     ; 2: 2\n" +
+		"0002 OpConstant   1 [2.000000]\n" +
+		"     ; 1: 1 +\n" +
+		"0004 OpAdd\n" +
+		"     ; 2: 2\n" +
+		"0005 OpReturn\n"
+	if out != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestDumpOpLengths(t *testing.T) {
+	for op := OpConstant; op <= OpReturn; op++ {
+		chunk := &Chunk{}
+		chunk.addOp(op, 1, 1)
+
+		want := 1
+		if op == OpConstant {
+			chunk.addByte(byte(chunk.addVal(numberValue(1))), 1, 1)
+			want = 2
+		}
+
+		var n int
+		out := capture(t, &os.Stdout, func() {
+			n = dumpOp(chunk, 0)
+		})
+		if n != want {
+			t.Errorf("%v: dumpOp returned %d, want %d", op, n, want)
+		}
+		if strings.Contains(out, "Op(") {
+			t.Errorf("%v: no mnemonic in %q", op, out)
+		}
+	}
+}
+
+func TestRuntimeErrorSourceName(t *testing.T) {
+	chunk, err := newCompiler(withSourceName("<repl>")).compile("1 +\n  nil")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = (&vm{}).run(chunk)
+	if err == nil || err.Error() != "<repl>:1: type mismatch" {
+		t.Errorf("got error %v, want it prefixed with the source name", err)
+	}
+}
+
+func TestExecutedLines(t *testing.T) {
+	vm := &vm{}
+
+	chunk, err := newCompiler().compile("1\n+ 2\n\n+ 3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	capture(t, &os.Stdout, func() {
+		err = vm.run(chunk)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := vm.ExecutedLines(); !reflect.DeepEqual(got, []int{1, 2, 4}) {
+		t.Errorf("got %v, want [1 2 4]", got)
+	}
+
+	// there are no branches yet, but a runtime error on line 2 leaves
+	// line 3 unexecuted, and the lines of the previous run are forgotten
+	chunk, err = newCompiler().compile("1\n+ nil\n+ 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.run(chunk); err == nil {
+		t.Fatal("expected a runtime error")
+	}
+	if got := vm.ExecutedLines(); !reflect.DeepEqual(got, []int{1, 2}) {
+		t.Errorf("got %v, want [1 2]", got)
+	}
+}
+
+func TestConcurrentVMs(t *testing.T) {
+	programs := []struct {
+		source string
+		want   Value
+	}{
+		{"1 + 2 * 3", numberValue(7)},
+		{"(2 ** 10 <=> 1000) == 1", boolValue(true)},
+	}
+
+	var wg sync.WaitGroup
+	for _, p := range programs {
+		p := p
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				chunk, err := newCompiler().compileExpression(p.source)
+				if err != nil {
+					t.Errorf("%s: %s", p.source, err)
+					return
+				}
+
+				vm := &vm{}
+				if err := vm.run(chunk); err != nil {
+					t.Errorf("%s: %s", p.source, err)
+					return
+				}
+				if got := vm.stack.pop(); got != p.want {
+					t.Errorf("%s: got %v, want %v", p.source, got, p.want)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}